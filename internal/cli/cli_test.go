@@ -0,0 +1,435 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/schema"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const CLIPPINGS_FILE_PATH = "../../testData/Test Clippings.txt"
+
+func executeCommand(args ...string) (string, error) {
+	return executeCommandWithInput(nil, args...)
+}
+
+func executeCommandWithInput(stdin io.Reader, args ...string) (string, error) {
+	cmd := newRootCmd()
+	out := &bytes.Buffer{}
+	cmd.SetOut(out)
+	cmd.SetErr(out)
+	cmd.SetIn(stdin)
+	cmd.SetArgs(args)
+
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestSubcommands(t *testing.T) {
+	tests := []struct {
+		name             string
+		args             []string
+		expectedContains []string
+		shouldError      bool
+	}{
+		{
+			name: "list books",
+			args: []string{"list", "--clippings", CLIPPINGS_FILE_PATH},
+			expectedContains: []string{
+				"Modern Software Engineering (Farley, David) - 1 highlights\nSandworm (Greenberg, Andy) - 2 highlights\n",
+			},
+		},
+		{
+			name: "show book",
+			args: []string{"show", "sandworm", "-c", CLIPPINGS_FILE_PATH},
+			expectedContains: []string{
+				"Sandworm (Greenberg, Andy)",
+				"- Test (Page: 305, Location: 4933-4934)",
+			},
+		},
+		{
+			name:        "show unknown book",
+			args:        []string{"show", "Dune", "-c", CLIPPINGS_FILE_PATH},
+			shouldError: true,
+		},
+		{
+			name: "search",
+			args: []string{"search", "-c", CLIPPINGS_FILE_PATH, "communication", "overhead"},
+			expectedContains: []string{
+				"Modern Software Engineering (Farley, David)",
+				`1 highlights match "communication overhead"`,
+			},
+		},
+		{
+			name: "stats",
+			args: []string{"stats", "-c", CLIPPINGS_FILE_PATH},
+			expectedContains: []string{
+				"Books:      2",
+				"Highlights: 3",
+				"First:      6 May 2024",
+				"Most highlighted: Sandworm (2)",
+			},
+		},
+		{
+			name:        "unknown output format",
+			args:        []string{"list", "-c", CLIPPINGS_FILE_PATH, "--format", "xml"},
+			shouldError: true,
+		},
+		{
+			name:        "missing clippings file",
+			args:        []string{"list", "-c", "non-existent-file.txt"},
+			shouldError: true,
+		},
+		{
+			name:        "export without a selection",
+			args:        []string{"export", "-c", CLIPPINGS_FILE_PATH},
+			shouldError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := executeCommand(tt.args...)
+
+			if tt.shouldError {
+				assert.Error(t, err, "Should return an error")
+				return
+			}
+
+			require.NoError(t, err, "Should run without error")
+			for _, expected := range tt.expectedContains {
+				assert.Contains(t, output, expected)
+			}
+		})
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	output, err := executeCommand("show", "Sandworm", "-c", CLIPPINGS_FILE_PATH, "--format", "json")
+	require.NoError(t, err, "Should run without error")
+
+	var document struct {
+		SchemaVersion int         `json:"schema_version"`
+		Kind          string      `json:"kind"`
+		Data          schema.Book `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(output), &document), "Should print valid JSON")
+
+	assert.Equal(t, schema.Version, document.SchemaVersion)
+	assert.Equal(t, "book", document.Kind)
+	assert.Equal(t, "Sandworm", document.Data.Title)
+	require.Len(t, document.Data.Highlights, 2)
+
+	highlight := document.Data.Highlights[0]
+	assert.Equal(t, document.Data.ID, highlight.BookID)
+	assert.Equal(t, &schema.Location{Start: 4933, End: 4934}, highlight.Location)
+	require.NotNil(t, highlight.AddedAt, "Should include the parsed date")
+	assert.Equal(t, "2024-05-06T19:53:44Z", highlight.AddedAt.Format("2006-01-02T15:04:05Z07:00"))
+}
+
+func TestNDJSONOutput(t *testing.T) {
+	output, err := executeCommand("list", "-c", CLIPPINGS_FILE_PATH, "--format", "ndjson")
+	require.NoError(t, err, "Should run without error")
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	require.Len(t, lines, 2, "Should print one line per book")
+
+	for _, line := range lines {
+		var book schema.Book
+		require.NoError(t, json.Unmarshal([]byte(line), &book), "Each line should be valid JSON")
+		assert.NotEmpty(t, book.ID)
+		assert.NotZero(t, book.HighlightCount)
+	}
+}
+
+func TestClippingsFromStdin(t *testing.T) {
+	file, err := os.Open(CLIPPINGS_FILE_PATH)
+	require.NoError(t, err, "Test file should open")
+	defer file.Close()
+
+	output, err := executeCommandWithInput(file, "list", "--clippings", "-")
+	require.NoError(t, err, "Should read clippings from stdin")
+	assert.Contains(t, output, "Sandworm (Greenberg, Andy) - 2 highlights")
+}
+
+func TestExportDryRun(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(configFile, []byte("notes_directory = \"notes\"\n"), 0644))
+
+	output, err := executeCommand("export", "--all", "--dry-run", "--config", configFile, "-c", CLIPPINGS_FILE_PATH)
+	require.NoError(t, err, "Should run without error")
+
+	notePath := filepath.Join(home, "notes", "Sandworm.md")
+	assert.Contains(t, output, "--- /dev/null\n+++ "+notePath+"\n")
+	assert.Contains(t, output, "+# Sandworm\n")
+	assert.NotContains(t, output, "state.json", "Should leave the sync state out of the changes")
+	assert.Contains(t, output, "Would export 3 new highlights across 2 books, skipping 0 duplicates")
+	assert.NotContains(t, output, "Exported")
+	assert.Contains(t, output, "Dry run: 2 files would be created, 0 modified and 0 deleted, nothing was written")
+	assert.NoDirExists(t, filepath.Join(home, "notes"), "Should not write anything")
+
+	output, err = executeCommand("export", "--all", "--dry-run", "--config", configFile, "-c", CLIPPINGS_FILE_PATH, "--format", "json")
+	require.NoError(t, err, "Should run without error")
+
+	var document struct {
+		Kind string              `json:"kind"`
+		Data []schema.FileChange `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(output), &document), "Should print valid JSON")
+	assert.Equal(t, "changes", document.Kind)
+	require.Len(t, document.Data, 2, "Should list both notes")
+	assert.Equal(t, "create", document.Data[0].Action)
+}
+
+func TestRestore(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(configFile, []byte("notes_directory = \"notes\"\n"), 0644))
+
+	notePath := filepath.Join(home, "notes", "Sandworm.md")
+	createdPath := filepath.Join(home, "notes", "Modern Software Engineering.md")
+	require.NoError(t, os.MkdirAll(filepath.Dir(notePath), 0755))
+	require.NoError(t, os.WriteFile(notePath, []byte("# Sandworm\n\nMy own notes\n"), 0644))
+
+	_, err := executeCommand("export", "--all", "--config", configFile, "-c", CLIPPINGS_FILE_PATH)
+	require.NoError(t, err, "Should export without error")
+
+	output, err := executeCommand("restore", "--config", configFile)
+	require.NoError(t, err, "Should restore without error")
+	assert.Contains(t, output, "Restored "+notePath+"\n")
+	assert.Contains(t, output, "Removed "+createdPath+"\n")
+	assert.Contains(t, output, "Rolled back the export of ")
+
+	content, err := os.ReadFile(notePath)
+	require.NoError(t, err)
+	assert.Equal(t, "# Sandworm\n\nMy own notes\n", string(content), "Should put back the note as it was before the export")
+	assert.NoFileExists(t, createdPath, "Should remove the notes the export created")
+
+	_, err = executeCommand("restore", "--config", configFile)
+	assert.ErrorContains(t, err, "nothing to restore")
+}
+
+func TestExportContinueOnError(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(configFile, []byte("notes_directory = \"notes\"\n"), 0644))
+
+	// A directory where the note should be makes the book fail
+	require.NoError(t, os.MkdirAll(filepath.Join(home, "notes", "Sandworm.md"), 0755))
+
+	output, err := executeCommand("export", "--all", "--on-error", "continue", "--config", configFile, "-c", CLIPPINGS_FILE_PATH)
+	require.Error(t, err, "Should report the failing book")
+	assert.Contains(t, output, "Sandworm: failed: ")
+	assert.Contains(t, output, "1 books failed to export")
+	assert.FileExists(t, filepath.Join(home, "notes", "Modern Software Engineering.md"), "Should export the other books")
+
+	_, err = executeCommand("export", "--all", "--on-error", "retry", "--config", configFile, "-c", CLIPPINGS_FILE_PATH)
+	assert.ErrorContains(t, err, `unknown error policy "retry"`)
+}
+
+func TestExportNegativeWorkers(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(configFile, []byte("notes_directory = \"notes\"\n"), 0644))
+
+	_, err := executeCommand("export", "--all", "--workers", "-3", "--config", configFile, "-c", CLIPPINGS_FILE_PATH)
+	assert.ErrorContains(t, err, "--workers must be 0 or more, got -3")
+	assert.NoDirExists(t, filepath.Join(home, "notes"), "Should not export anything")
+}
+
+func TestExportBookOverrides(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(configFile, []byte(`notes_directory = "notes"
+
+[[books]]
+book = "Sandworm"
+ignore = true
+
+[[books]]
+book = "Modern Software Engineering"
+folder = "engineering"
+title = "MSE"
+`), 0644))
+
+	output, err := executeCommand("export", "--all", "--config", configFile, "-c", CLIPPINGS_FILE_PATH)
+	require.NoError(t, err, "Should export without error")
+	assert.NotContains(t, output, "Sandworm", "Should leave out ignored books")
+	assert.NoFileExists(t, filepath.Join(home, "notes", "Sandworm.md"))
+
+	content, err := os.ReadFile(filepath.Join(home, "notes", "engineering", "MSE.md"))
+	require.NoError(t, err, "Should write the note in its folder under its display title")
+	assert.Contains(t, string(content), "# MSE\n")
+}
+
+func TestExportStreamsFromStdin(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(configFile, []byte("notes_directory = \"notes\"\n"), 0644))
+
+	clippings, err := os.ReadFile(CLIPPINGS_FILE_PATH)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "format json", args: []string{"export", "--format", "json", "-"}},
+		{name: "explicit format and output", args: []string{"export", "--all", "--to", "json", "-o", "-", "-c", "-"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := newRootCmd()
+			var stdout, stderr bytes.Buffer
+			cmd.SetOut(&stdout)
+			cmd.SetErr(&stderr)
+			cmd.SetIn(bytes.NewReader(clippings))
+			cmd.SetArgs(append(tt.args, "--config", configFile))
+			require.NoError(t, cmd.Execute(), "Should export without error")
+
+			var document struct {
+				Kind string            `json:"kind"`
+				Data []json.RawMessage `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(stdout.Bytes(), &document), "Should stream the JSON export to stdout")
+			assert.Len(t, document.Data, 2, "Should export every book")
+			assert.Contains(t, stdout.String(), "Sandworm")
+			assert.Contains(t, stderr.String(), "Exported 3 new highlights", "Should print the summary to stderr")
+			assert.NoDirExists(t, filepath.Join(home, "notes"), "Should write no notes")
+		})
+	}
+}
+
+func TestListBookOverrides(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(configFile, []byte(`[[books]]
+book = "Sandworm"
+ignore = true
+
+[[books]]
+book = "Modern Software Engineering"
+title = "MSE"
+`), 0644))
+
+	tests := []struct {
+		name             string
+		args             []string
+		expectedContains []string
+	}{
+		{name: "list", args: []string{"list"}, expectedContains: []string{"MSE (Farley, David) - 1 highlights\n"}},
+		{name: "show by display title", args: []string{"show", "mse"}, expectedContains: []string{"MSE (Farley, David)"}},
+		{name: "show by Kindle title", args: []string{"show", "Modern Software Engineering"}, expectedContains: []string{"MSE (Farley, David)"}},
+		{name: "search", args: []string{"search", "e"}, expectedContains: []string{"MSE (Farley, David)", "1 highlights match"}},
+		{name: "stats", args: []string{"stats"}, expectedContains: []string{"Books:      1", "Highlights: 1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := executeCommand(append(tt.args, "--config", configFile, "-c", CLIPPINGS_FILE_PATH)...)
+			require.NoError(t, err, "Should run without error")
+			assert.NotContains(t, output, "Sandworm", "Should hide ignored books")
+			assert.NotContains(t, output, "Modern Software Engineering", "Should show the display title")
+			for _, expected := range tt.expectedContains {
+				assert.Contains(t, output, expected)
+			}
+		})
+	}
+
+	// The ID stays the one of the Kindle title, which overrides can match
+	output, err := executeCommand("list", "--format", "ndjson", "--config", configFile, "-c", CLIPPINGS_FILE_PATH)
+	require.NoError(t, err, "Should run without error")
+	var book schema.Book
+	require.NoError(t, json.Unmarshal([]byte(output), &book))
+	assert.Equal(t, models.BookGroup{Title: "Modern Software Engineering", Author: "Farley, David"}.ID(), book.ID)
+}
+
+func TestExportMirror(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.toml")
+	require.NoError(t, os.WriteFile(configFile, []byte("notes_directory = \"notes\"\nmanaged_regions = true\n"), 0644))
+	notePath := filepath.Join(home, "notes", "Sandworm.md")
+
+	_, err := executeCommand("export", "--all", "--config", configFile, "-c", CLIPPINGS_FILE_PATH)
+	require.NoError(t, err, "Should export without error")
+	before, err := os.ReadFile(notePath)
+	require.NoError(t, err)
+	require.Contains(t, string(before), "- Test (Page: 305)\n")
+
+	// The "Test" highlight is deleted on the device
+	clippings, err := os.ReadFile(CLIPPINGS_FILE_PATH)
+	require.NoError(t, err)
+	entries := strings.SplitAfter(string(clippings), "==========\n")
+	edited := filepath.Join(dir, "My Clippings.txt")
+	require.NoError(t, os.WriteFile(edited, []byte(entries[0]+entries[1]), 0644))
+
+	output, err := executeCommand("export", "--all", "--mirror", "--config", configFile, "-c", edited)
+	assert.ErrorContains(t, err, "mirror would remove 1 highlights", "Should stop at the dry run")
+	assert.Contains(t, output, "--- "+notePath+"\n")
+	assert.Contains(t, output, "-- Test (Page: 305)\n")
+	after, err := os.ReadFile(notePath)
+	require.NoError(t, err)
+	assert.Equal(t, string(before), string(after), "Should not remove anything without --yes")
+
+	output, err = executeCommand("export", "--all", "--mirror", "--yes", "--config", configFile, "-c", edited)
+	require.NoError(t, err, "Should export without error")
+	assert.Contains(t, output, "Sandworm: 0 new, 1 skipped, 1 removed (1 total)\n")
+	after, err = os.ReadFile(notePath)
+	require.NoError(t, err)
+	assert.NotContains(t, string(after), "- Test (Page: 305)\n")
+	assert.Contains(t, string(after), "cascading failures")
+}
+
+func TestExportMirrorSince(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.toml")
+	require.NoError(t, os.WriteFile(configFile, []byte("notes_directory = \"notes\"\nmanaged_regions = true\n"), 0644))
+	notePath := filepath.Join(home, "notes", "Sandworm.md")
+
+	_, err := executeCommand("export", "--all", "--config", configFile, "-c", CLIPPINGS_FILE_PATH)
+	require.NoError(t, err, "Should export without error")
+
+	// A newer Sandworm highlight is the only one since the first export
+	clippings, err := os.ReadFile(CLIPPINGS_FILE_PATH)
+	require.NoError(t, err)
+	newer := "Sandworm (Greenberg, Andy)\n- Your Highlight on page 310 | location 5000-5001 | Added on Saturday, 1 June 2024 10:00:00\n\nNewer\n==========\n"
+	updated := filepath.Join(dir, "My Clippings.txt")
+	require.NoError(t, os.WriteFile(updated, append(clippings, newer...), 0644))
+
+	output, err := executeCommand("export", "--since", "2024-06-01", "--mirror", "--yes", "--config", configFile, "-c", updated)
+	require.NoError(t, err, "Should export without error")
+	assert.Contains(t, output, "Sandworm: 1 new, 0 skipped (1 total)\n", "Should not remove highlights outside the selection")
+	after, err := os.ReadFile(notePath)
+	require.NoError(t, err)
+	assert.Contains(t, string(after), "- Test (Page: 305)\n", "Older highlights still in the source should survive")
+	assert.Contains(t, string(after), "cascading failures")
+	assert.Contains(t, string(after), "Newer")
+}
@@ -0,0 +1,275 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/exporter"
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/filter"
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/schema"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const sinceLayout = "2006-01-02"
+
+type exportOptions struct {
+	all     bool
+	books   []string
+	since   string
+	target  string
+	output  string
+	merge   string
+	onError string
+	workers int
+	mirror  bool
+	yes     bool
+	dryRun  bool
+	format  string
+}
+
+func newExportCmd(global *globalOptions) *cobra.Command {
+	opts := &exportOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "export [clippings-file]",
+		Short: "Export highlights without opening the interface",
+		Example: `  kindle-highlights export --all
+  kindle-highlights export --book "Sandworm" --since 2024-05-01
+  kindle-highlights export --all --dry-run
+  kindle-highlights export --all --mirror
+  cat "My Clippings.txt" | kindle-highlights export --format json -
+  cat "My Clippings.txt" | kindle-highlights export --all --to readwise -o - -`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 && args[0] == stdinPath {
+				opts.pipe()
+			}
+
+			out := cmd.OutOrStdout()
+			if opts.output == exporter.StdoutPath {
+				// Keep stdout for the export itself
+				out = cmd.ErrOrStderr()
+			}
+			return runExport(cmd.OutOrStdout(), out, global.withArgs(args), opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.all, "all", false, "export every highlight")
+	cmd.Flags().StringArrayVar(&opts.books, "book", nil, "only export this book title (repeatable)")
+	cmd.Flags().StringVar(&opts.since, "since", "", "only export highlights added on or after this date (YYYY-MM-DD)")
+	cmd.Flags().StringVar(&opts.target, "to", "", "export format, overriding export_format in config.toml")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "", "file for single-file formats, or - for stdout, overriding export_file in config.toml")
+	cmd.Flags().StringVar(&opts.merge, "merge", "", "how new highlights join existing notes: append or ordered, overriding merge in config.toml")
+	cmd.Flags().StringVar(&opts.onError, "on-error", "", "when a book fails: stop, continue with the other books, or rollback to write nothing; overrides on_error in config.toml")
+	cmd.Flags().IntVar(&opts.workers, "workers", 0, "number of books exported at once, overriding workers in config.toml")
+	cmd.Flags().BoolVar(&opts.mirror, "mirror", false, "remove managed highlights that are no longer in the source, after a dry run unless --yes is given")
+	cmd.Flags().BoolVar(&opts.yes, "yes", false, "remove the highlights a mirror export finds without stopping at the dry run")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "print a diff of every file the export would change without writing anything")
+	addFormatFlag(cmd, &opts.format)
+
+	return cmd
+}
+
+// pipe makes "export --format json -" a pipeline: with the clippings piped
+// in and no other export format or output given, the selection, or every
+// highlight, is streamed to stdout as JSON and the summary goes to stderr.
+func (o *exportOptions) pipe() {
+	if o.format != formatJSON || o.target != "" || o.output != "" {
+		return
+	}
+
+	o.target, o.output, o.format = string(exporter.FormatJSON), exporter.StdoutPath, formatTable
+	if len(o.books) == 0 && o.since == "" {
+		o.all = true
+	}
+}
+
+// runExport writes the export itself to stdout when requested and the
+// summary to out.
+func runExport(stdout, out io.Writer, global *globalOptions, opts *exportOptions) error {
+	if err := validateFormat(opts.format); err != nil {
+		return err
+	}
+
+	if !opts.all && len(opts.books) == 0 && opts.since == "" {
+		return errors.New("nothing selected: pass --all, --book or --since")
+	}
+
+	if opts.workers < 0 {
+		return fmt.Errorf("--workers must be 0 or more, got %d", opts.workers)
+	}
+
+	filterOpts := filter.Options{Books: opts.books}
+	if opts.since != "" {
+		since, err := time.Parse(sinceLayout, opts.since)
+		if err != nil {
+			return fmt.Errorf("invalid --since %q: expected YYYY-MM-DD", opts.since)
+		}
+		filterOpts.Since = since
+	}
+
+	cfg, err := global.loadConfig()
+	if err != nil {
+		return err
+	}
+	if opts.target != "" {
+		cfg.ExportFormat = opts.target
+	}
+	if opts.output != "" {
+		cfg.ExportFile = opts.output
+	}
+	if opts.merge != "" {
+		cfg.Merge = opts.merge
+	}
+	if opts.onError != "" {
+		cfg.OnError = opts.onError
+	}
+	if opts.workers > 0 {
+		cfg.Workers = opts.workers
+	}
+	if opts.mirror {
+		cfg.Mirror = true
+	}
+
+	highlights, err := global.loadHighlights()
+	if err != nil {
+		return err
+	}
+
+	// Mirroring compares notes against every highlight, not the selection
+	source := highlights
+	highlights = filter.Apply(highlights, filterOpts)
+
+	if opts.dryRun {
+		var preview exporter.Preview
+		if len(highlights) > 0 {
+			service := exporter.New(cfg)
+			service.SetSource(source)
+			preview, err = service.DryRun(groupByTitle(highlights))
+		}
+		if writeErr := writePreview(out, opts.format, preview); writeErr != nil && err == nil {
+			err = writeErr
+		}
+		return err
+	}
+
+	// Show what mirroring would remove before removing anything
+	if cfg.Mirror && !opts.yes && len(highlights) > 0 {
+		service := exporter.New(cfg)
+		service.SetSource(source)
+		preview, err := service.DryRun(groupByTitle(highlights))
+		if err != nil {
+			return err
+		}
+		if removed := preview.Removed(); removed > 0 {
+			if err := writePreview(out, opts.format, preview); err != nil {
+				return err
+			}
+			return fmt.Errorf("mirror would remove %d highlights no longer in the source; check the changes above and run again with --yes to remove them", removed)
+		}
+	}
+
+	var results []models.ExportResult
+	if len(highlights) > 0 {
+		service := exporter.New(cfg)
+		service.SetStdout(stdout)
+		service.SetSource(source)
+		results, err = service.ExportHighlights(groupByTitle(highlights))
+	}
+
+	if writeErr := writeRecords(out, opts.format, "export_results", schema.FromExportResults(results), func() {
+		printExportResults(out, results, false)
+	}); writeErr != nil && err == nil {
+		err = writeErr
+	}
+
+	return err
+}
+
+func groupByTitle(highlights []models.Highlight) map[string][]models.Highlight {
+	bookHighlights := make(map[string][]models.Highlight)
+	for _, highlight := range highlights {
+		bookHighlights[highlight.Title] = append(bookHighlights[highlight.Title], highlight)
+	}
+	return bookHighlights
+}
+
+// printExportResults prints a line per book and the totals, worded as what
+// would happen for a dry run.
+func printExportResults(out io.Writer, results []models.ExportResult, dryRun bool) {
+	newCount, skippedCount, failedCount := 0, 0, 0
+
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Fprintf(out, "%s: failed: %v\n", result.BookTitle, result.Err)
+			failedCount++
+			continue
+		}
+
+		updated := ""
+		if result.UpdatedCount > 0 {
+			updated = fmt.Sprintf(", %d updated", result.UpdatedCount)
+		}
+		if result.RemovedCount > 0 {
+			updated += fmt.Sprintf(", %d removed", result.RemovedCount)
+		}
+		fmt.Fprintf(out, "%s: %d new, %d skipped%s (%d total)\n",
+			result.BookTitle, result.NewCount, result.SkippedCount, updated, result.TotalCount)
+		for _, conflict := range result.Conflicts {
+			fmt.Fprintf(out, "  conflict: highlight %s %s, left unchanged\n", conflict.HighlightID, conflict.Reason)
+		}
+		newCount += result.NewCount
+		skippedCount += result.SkippedCount
+	}
+
+	if dryRun {
+		fmt.Fprintf(out, "Would export %d new highlights across %d books, skipping %d duplicates\n",
+			newCount, len(results)-failedCount, skippedCount)
+		if failedCount > 0 {
+			fmt.Fprintf(out, "%d books would fail to export\n", failedCount)
+		}
+		return
+	}
+
+	fmt.Fprintf(out, "Exported %d new highlights across %d books, skipped %d duplicates\n",
+		newCount, len(results)-failedCount, skippedCount)
+	if failedCount > 0 {
+		fmt.Fprintf(out, "%d books failed to export\n", failedCount)
+	}
+}
+
+// writePreview prints the diff of every file a dry run would change, then
+// the results and counts. JSON formats list the changes instead.
+func writePreview(out io.Writer, format string, preview exporter.Preview) error {
+	changes := make([]schema.FileChange, 0, len(preview.Changes))
+	for _, change := range preview.Changes {
+		action := "modify"
+		switch {
+		case change.Created:
+			action = "create"
+		case change.Deleted:
+			action = "delete"
+		}
+		changes = append(changes, schema.FileChange{Path: change.Path, Action: action, Diff: change.Diff()})
+	}
+
+	return writeRecords(out, format, "changes", changes, func() {
+		for _, change := range changes {
+			fmt.Fprint(out, change.Diff)
+		}
+		if len(changes) > 0 {
+			fmt.Fprintln(out)
+		}
+
+		printExportResults(out, preview.Results, true)
+		created, modified, deleted := preview.Counts()
+		if removed := preview.Removed(); removed > 0 {
+			fmt.Fprintf(out, "%d highlights would be removed\n", removed)
+		}
+		fmt.Fprintf(out, "Dry run: %d files would be created, %d modified and %d deleted, nothing was written\n", created, modified, deleted)
+	})
+}
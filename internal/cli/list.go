@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/schema"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+func newListCmd(opts *globalOptions) *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List books with their highlight counts",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateFormat(format); err != nil {
+				return err
+			}
+
+			books, err := opts.loadBooks()
+			if err != nil {
+				return err
+			}
+
+			records := make([]schema.Book, 0, len(books))
+			for _, book := range books {
+				records = append(records, schema.FromBook(book, false))
+			}
+
+			out := cmd.OutOrStdout()
+			return writeRecords(out, format, "books", records, func() {
+				printBooks(out, books)
+			})
+		},
+	}
+
+	addFormatFlag(cmd, &format)
+
+	return cmd
+}
+
+func printBooks(out io.Writer, books []models.BookGroup) {
+	for _, book := range books {
+		fmt.Fprintf(out, "%s (%s) - %d highlights\n", book.Title, book.Author, len(book.Highlights))
+	}
+}
@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/schema"
+)
+
+const (
+	formatTable  = "table"
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+)
+
+func addFormatFlag(cmd *cobra.Command, format *string) {
+	cmd.Flags().StringVarP(format, "format", "f", formatTable, "output format: table, json or ndjson")
+	cmd.RegisterFlagCompletionFunc("format", cobra.FixedCompletions(
+		[]string{formatTable, formatJSON, formatNDJSON}, cobra.ShellCompDirectiveNoFileComp))
+}
+
+func validateFormat(format string) error {
+	switch format {
+	case formatTable, formatJSON, formatNDJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q: expected table, json or ndjson", format)
+}
+
+// writeRecords prints records as a JSON document or as one JSON object per
+// line, falling back to table for the human-readable layout.
+func writeRecords[T any](out io.Writer, format, kind string, records []T, table func()) error {
+	switch format {
+	case formatJSON:
+		return writeDocument(out, kind, records)
+	case formatNDJSON:
+		encoder := json.NewEncoder(out)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	table()
+	return nil
+}
+
+// writeRecord is writeRecords for commands that print a single object.
+func writeRecord[T any](out io.Writer, format, kind string, record T, table func()) error {
+	if format == formatJSON {
+		return writeDocument(out, kind, record)
+	}
+	return writeRecords(out, format, kind, []T{record}, table)
+}
+
+func writeDocument(out io.Writer, kind string, data any) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(schema.Document{
+		SchemaVersion: schema.Version,
+		Kind:          kind,
+		Data:          data,
+	})
+}
@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/exporter"
+)
+
+const restoreTimeFormat = "2 January 2006 15:04:05"
+
+func newRestoreCmd(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "restore",
+		Short: "Roll back the files changed by the last export",
+		Long:  "Roll back the files changed by the last export, putting modified notes back from their backups and removing the notes it created.\n\nRun it again to roll back the export before that.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := opts.loadConfig()
+			if err != nil {
+				return err
+			}
+
+			result, err := exporter.New(cfg).Restore()
+			if errors.Is(err, exporter.ErrNoBackups) {
+				return fmt.Errorf("nothing to restore: no export has been backed up in %s", cfg.NotesDirectory)
+			}
+			if err != nil {
+				return fmt.Errorf("restoring: %w", err)
+			}
+
+			printRestoreResult(cmd.OutOrStdout(), result)
+			return nil
+		},
+	}
+}
+
+func printRestoreResult(out io.Writer, result exporter.RestoreResult) {
+	for _, path := range result.Restored {
+		fmt.Fprintf(out, "Restored %s\n", path)
+	}
+	for _, path := range result.Removed {
+		fmt.Fprintf(out, "Removed %s\n", path)
+	}
+	fmt.Fprintf(out, "Rolled back the export of %s: %d files restored, %d removed\n",
+		result.RunTime.Local().Format(restoreTimeFormat), len(result.Restored), len(result.Removed))
+}
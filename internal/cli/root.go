@@ -0,0 +1,190 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/config"
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/parser"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const (
+	defaultClippingsFile = "My Clippings.txt"
+	stdinPath            = "-"
+)
+
+// globalOptions holds the persistent flags shared by every subcommand.
+type globalOptions struct {
+	clippingsFile string
+	configFile    string
+	stdin         io.Reader
+}
+
+// Execute runs the command line interface.
+func Execute() error {
+	return newRootCmd().Execute()
+}
+
+func newRootCmd() *cobra.Command {
+	opts := &globalOptions{}
+
+	cmd := &cobra.Command{
+		Use:           "kindle-highlights",
+		Short:         "Parse and organise Kindle highlights into markdown notes",
+		Long:          "Parse and organise Kindle highlights into markdown notes.\n\nRunning without a subcommand opens the interactive interface.",
+		Args:          cobra.MaximumNArgs(1),
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			opts.stdin = cmd.InOrStdin()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runTUI(opts.withArgs(args))
+		},
+	}
+
+	cmd.PersistentFlags().StringVarP(&opts.clippingsFile, "clippings", "c", defaultClippingsFile, "path to My Clippings.txt or a Readwise CSV export, or - for stdin")
+	cmd.PersistentFlags().StringVar(&opts.configFile, "config", "", "path to the config file (default ./config.toml)")
+
+	cmd.AddCommand(
+		newListCmd(opts),
+		newShowCmd(opts),
+		newExportCmd(opts),
+		newStatsCmd(opts),
+		newSearchCmd(opts),
+		newRestoreCmd(opts),
+		newTUICmd(opts),
+	)
+
+	return cmd
+}
+
+// withArgs lets a positional clippings file override the --clippings flag.
+func (o *globalOptions) withArgs(args []string) *globalOptions {
+	if len(args) == 0 {
+		return o
+	}
+
+	withFile := *o
+	withFile.clippingsFile = args[0]
+	return &withFile
+}
+
+func (o *globalOptions) loadConfig() (*config.Config, error) {
+	return config.LoadFile(o.configFile)
+}
+
+// loadOverrides reads the per-book overrides from the config file. Commands
+// that only read the clippings also work without one.
+func (o *globalOptions) loadOverrides() (*config.Config, error) {
+	cfg, err := o.loadConfig()
+	if errors.As(err, &viper.ConfigFileNotFoundError{}) {
+		return &config.Config{}, nil
+	}
+	return cfg, err
+}
+
+// loadHighlights parses the clippings, leaving out the books config.toml
+// says to ignore.
+func (o *globalOptions) loadHighlights() ([]models.Highlight, error) {
+	highlights, err := o.parseHighlights()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := o.loadOverrides()
+	if err != nil {
+		return nil, err
+	}
+
+	visible := highlights[:0]
+	for _, highlight := range highlights {
+		book := models.BookGroup{Title: highlight.Title, Author: highlight.Author}
+		if !cfg.Book(book.Title, book.ID()).Ignore {
+			visible = append(visible, highlight)
+		}
+	}
+	return visible, nil
+}
+
+func (o *globalOptions) parseHighlights() ([]models.Highlight, error) {
+	if o.clippingsFile == stdinPath {
+		highlights, err := parser.ParseReader(o.stdin)
+		if err != nil {
+			return nil, fmt.Errorf("parsing clippings from stdin: %w", err)
+		}
+		return highlights, nil
+	}
+
+	if _, err := os.Stat(o.clippingsFile); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s not found. Please place your Kindle clippings file in the current directory or pass its path with --clippings", o.clippingsFile)
+	}
+
+	highlights, err := parser.Parse(o.clippingsFile)
+	if err != nil {
+		return nil, fmt.Errorf("parsing clippings: %w", err)
+	}
+
+	return highlights, nil
+}
+
+// loadBooks groups the parsed highlights by book, sorted by title.
+func (o *globalOptions) loadBooks() ([]models.BookGroup, error) {
+	highlights, err := o.loadHighlights()
+	if err != nil {
+		return nil, err
+	}
+
+	return o.groupBooks(highlights)
+}
+
+// groupBooks groups highlights by book under the titles config.toml gives
+// them, sorted by title.
+func (o *globalOptions) groupBooks(highlights []models.Highlight) ([]models.BookGroup, error) {
+	cfg, err := o.loadOverrides()
+	if err != nil {
+		return nil, err
+	}
+
+	books := parser.GroupHighlightsByBook(highlights)
+	for i, book := range books {
+		if title := cfg.DisplayTitle(book.Title, book.ID()); title != book.Title {
+			books[i].SourceTitle, books[i].Title = book.Title, title
+		}
+	}
+	return sortBooks(books), nil
+}
+
+func sortBooks(books []models.BookGroup) []models.BookGroup {
+	sort.Slice(books, func(i, j int) bool {
+		return books[i].Title < books[j].Title
+	})
+	return books
+}
+
+// completeBookTitles offers the book titles from the clippings file as
+// shell completions.
+func (o *globalOptions) completeBookTitles(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	books, err := o.loadBooks()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	titles := make([]string, 0, len(books))
+	for _, book := range books {
+		titles = append(titles, book.Title)
+	}
+
+	return titles, cobra.ShellCompDirectiveNoFileComp
+}
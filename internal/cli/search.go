@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/filter"
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/schema"
+)
+
+func newSearchCmd(opts *globalOptions) *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "search <query>",
+		Short: "Find highlights containing the query in their text, note, title or author",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateFormat(format); err != nil {
+				return err
+			}
+
+			highlights, err := opts.loadHighlights()
+			if err != nil {
+				return err
+			}
+
+			query := strings.Join(args, " ")
+			matches := filter.Apply(highlights, filter.Options{Query: query})
+			books, err := opts.groupBooks(matches)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			return writeRecords(out, format, "highlights", schema.FromHighlights(matches), func() {
+				for _, book := range books {
+					printBook(out, book)
+					fmt.Fprintln(out)
+				}
+				fmt.Fprintf(out, "%d highlights match %q\n", len(matches), query)
+			})
+		},
+	}
+
+	addFormatFlag(cmd, &format)
+
+	return cmd
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/schema"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+func newShowCmd(opts *globalOptions) *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:               "show <book>",
+		Short:             "Print every highlight from a book",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: opts.completeBookTitles,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateFormat(format); err != nil {
+				return err
+			}
+
+			books, err := opts.loadBooks()
+			if err != nil {
+				return err
+			}
+
+			book, ok := findBook(books, args[0])
+			if !ok {
+				return fmt.Errorf("book %q not found", args[0])
+			}
+
+			out := cmd.OutOrStdout()
+			return writeRecord(out, format, "book", schema.FromBook(book, true), func() {
+				printBook(out, book)
+			})
+		},
+	}
+
+	addFormatFlag(cmd, &format)
+
+	return cmd
+}
+
+func findBook(books []models.BookGroup, title string) (models.BookGroup, bool) {
+	title = strings.TrimSpace(title)
+	for _, book := range books {
+		if strings.EqualFold(book.Title, title) || (book.SourceTitle != "" && strings.EqualFold(book.SourceTitle, title)) {
+			return book, true
+		}
+	}
+	return models.BookGroup{}, false
+}
+
+func printBook(out io.Writer, book models.BookGroup) {
+	fmt.Fprintf(out, "%s (%s)\n\n", book.Title, book.Author)
+	for _, highlight := range book.Highlights {
+		printHighlight(out, highlight)
+	}
+}
+
+func printHighlight(out io.Writer, highlight models.Highlight) {
+	fmt.Fprintf(out, "- %s (Page: %s, Location: %s)\n", highlight.Text, highlight.Page, highlight.Location)
+	if highlight.Note != "" {
+		fmt.Fprintf(out, "  Note: %s\n", highlight.Note)
+	}
+}
@@ -0,0 +1,127 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/parser"
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/schema"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const statsDateFormat = "2 January 2006"
+
+type libraryStats struct {
+	Books      int
+	Authors    int
+	Highlights int
+	Notes      int
+	First      time.Time
+	Last       time.Time
+	TopBook    models.BookGroup
+}
+
+func newStatsCmd(opts *globalOptions) *cobra.Command {
+	var format string
+
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Summarise the highlight library",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateFormat(format); err != nil {
+				return err
+			}
+
+			books, err := opts.loadBooks()
+			if err != nil {
+				return err
+			}
+
+			stats := computeStats(books)
+
+			out := cmd.OutOrStdout()
+			return writeRecord(out, format, "stats", stats.toSchema(), func() {
+				printStats(out, stats)
+			})
+		},
+	}
+
+	addFormatFlag(cmd, &format)
+
+	return cmd
+}
+
+func computeStats(books []models.BookGroup) libraryStats {
+	stats := libraryStats{Books: len(books)}
+	authors := make(map[string]bool)
+
+	for _, book := range books {
+		authors[book.Author] = true
+		if len(book.Highlights) > len(stats.TopBook.Highlights) {
+			stats.TopBook = book
+		}
+
+		for _, highlight := range book.Highlights {
+			stats.Highlights++
+			if highlight.Note != "" {
+				stats.Notes++
+			}
+
+			added, err := parser.ParseDate(highlight.Date)
+			if err != nil {
+				continue
+			}
+			if stats.First.IsZero() || added.Before(stats.First) {
+				stats.First = added
+			}
+			if added.After(stats.Last) {
+				stats.Last = added
+			}
+		}
+	}
+
+	stats.Authors = len(authors)
+	return stats
+}
+
+func (s libraryStats) toSchema() schema.Stats {
+	result := schema.Stats{
+		Books:      s.Books,
+		Authors:    s.Authors,
+		Highlights: s.Highlights,
+		Notes:      s.Notes,
+	}
+
+	if !s.First.IsZero() {
+		result.FirstAddedAt = &s.First
+		result.LastAddedAt = &s.Last
+	}
+
+	if s.TopBook.Title != "" {
+		result.TopBookID = s.TopBook.ID()
+		result.TopBookTitle = s.TopBook.Title
+		result.TopBookHighlights = len(s.TopBook.Highlights)
+	}
+
+	return result
+}
+
+func printStats(out io.Writer, stats libraryStats) {
+	fmt.Fprintf(out, "Books:      %d\n", stats.Books)
+	fmt.Fprintf(out, "Authors:    %d\n", stats.Authors)
+	fmt.Fprintf(out, "Highlights: %d\n", stats.Highlights)
+	fmt.Fprintf(out, "Notes:      %d\n", stats.Notes)
+
+	if !stats.First.IsZero() {
+		fmt.Fprintf(out, "First:      %s\n", stats.First.Format(statsDateFormat))
+		fmt.Fprintf(out, "Last:       %s\n", stats.Last.Format(statsDateFormat))
+	}
+
+	if stats.TopBook.Title != "" {
+		fmt.Fprintf(out, "Most highlighted: %s (%d)\n", stats.TopBook.Title, len(stats.TopBook.Highlights))
+	}
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/spf13/cobra"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/tui"
+)
+
+func newTUICmd(opts *globalOptions) *cobra.Command {
+	return &cobra.Command{
+		Use:   "tui [clippings-file]",
+		Short: "Open the interactive interface",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runTUI(opts.withArgs(args))
+		},
+	}
+}
+
+func runTUI(opts *globalOptions) error {
+	cfg, err := opts.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	highlights, err := opts.loadHighlights()
+	if err != nil {
+		return err
+	}
+
+	programOpts := []tea.ProgramOption{tea.WithAltScreen()}
+	if opts.clippingsFile == stdinPath {
+		// Stdin carried the clippings, so read keys from the terminal
+		programOpts = append(programOpts, tea.WithInputTTY())
+	}
+
+	p := tea.NewProgram(tui.NewModel(cfg, highlights), programOpts...)
+	if _, err := p.Run(); err != nil {
+		return fmt.Errorf("running program: %w", err)
+	}
+
+	return nil
+}
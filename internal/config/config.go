@@ -1,8 +1,10 @@
 package config
 
 import (
-	"log"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/viper"
 )
@@ -10,29 +12,199 @@ import (
 type Config struct {
 	NotesDirectory string
 	HomeDir        string
+	ExportFormat   string
+	ExportFile     string
+	Merge          string // How new highlights join an existing note: "append" or "ordered"
+	ManagedRegions bool   // Wrap each highlight in markers so re-exports can refresh it
+	Mirror         bool   // Remove managed highlights that are no longer in the source
+	Backups        int    // Export runs whose backups are kept; 0 turns backups off
+	OnError        string // What a failing book does to the others: "stop", "continue" or "rollback"
+	Filenames      string // How book notes are named: "title", "author-title", "slug", "id" or "author-folder"
+	Workers        int    // Books exported at once; 0 uses one per CPU
+	Templates      Templates
+	FrontMatter    FrontMatter
+	Obsidian       Obsidian
+	Anki           Anki
+	Zettelkasten   Zettelkasten
+	Books          []Book // Per-book overrides
 }
 
-func Load() *Config {
-	viper.SetConfigName("config")
-	viper.SetConfigType("toml")
-	viper.AddConfigPath(".")
+// Book overrides how one book is exported. It applies to the book whose
+// title (ignoring case) or ID equals Match.
+type Book struct {
+	Match    string   `mapstructure:"book"`
+	Filename string   // Note name, without extension, relative to Folder
+	Folder   string   // Folder of the note, relative to the notes directory
+	Tags     []string // Added to the tags of front matter
+	Title    string   // Shown instead of the title from the Kindle
+	Ignore   bool     // Never export or list the book
+}
+
+// DisplayTitle returns the title to show for the book with the given title
+// and ID.
+func (c *Config) DisplayTitle(title, id string) string {
+	if display := c.Book(title, id).Title; display != "" {
+		return display
+	}
+	return title
+}
+
+// Book returns the overrides of the book with the given title and ID, or
+// none when no entry matches.
+func (c *Config) Book(title, id string) Book {
+	for _, book := range c.Books {
+		if book.Match != "" && (strings.EqualFold(book.Match, title) || book.Match == id) {
+			return book
+		}
+	}
+	return Book{}
+}
+
+// Zettelkasten holds the options of the zettelkasten export format.
+type Zettelkasten struct {
+	Directory string // Directory of the highlight notes, relative to the notes directory
+}
+
+// Anki holds the options of the anki and apkg export formats.
+type Anki struct {
+	Deck  string // Deck the cards are imported into
+	Cloze bool   // Write cloze deletions instead of front/back cards
+}
+
+// Obsidian holds the options of the obsidian export format.
+type Obsidian struct {
+	Index    string // Index note listing every exported book, relative to the notes directory
+	Callouts bool   // Render highlights as > [!quote] callouts
+}
+
+// FrontMatter controls the YAML front matter block kept at the top of each
+// book's note.
+type FrontMatter struct {
+	Enabled bool
+	Source  string
+	Tags    []string
+}
+
+// Templates holds the paths of the text/template files used to lay out each
+// book's note. Empty paths fall back to the default markdown layout.
+type Templates struct {
+	Header    string
+	Highlight string
+	Footer    string
+	Extension string
+}
+
+// Load reads config.toml from the current directory.
+func Load() (*Config, error) {
+	return LoadFile("")
+}
+
+// LoadFile reads the given config file, or config.toml from the current
+// directory when path is empty.
+func LoadFile(path string) (*Config, error) {
+	v := viper.New()
 
-	if err := viper.ReadInConfig(); err != nil {
-		log.Fatalf("Warning: Could not read config file: %v", err)
+	if path != "" {
+		v.SetConfigFile(path)
+	} else {
+		v.SetConfigName("config")
+		v.SetConfigType("toml")
+		v.AddConfigPath(".")
+	}
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("could not read config file: %w", err)
 	}
 
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		log.Fatalf("Error loading home directory: %v", err)
+		return nil, fmt.Errorf("loading home directory: %w", err)
 	}
 
-	notesDirectory := viper.GetString("notes_directory")
+	notesDirectory := v.GetString("notes_directory")
 	if notesDirectory == "" {
 		notesDirectory = "notes"
 	}
 
+	source := v.GetString("front_matter.source")
+	if source == "" {
+		source = "kindle"
+	}
+
+	deck := v.GetString("anki.deck")
+	if deck == "" {
+		deck = "Kindle Highlights"
+	}
+
+	zettelDirectory := v.GetString("zettelkasten.directory")
+	if zettelDirectory == "" {
+		zettelDirectory = "zettel"
+	}
+
+	backups := 10
+	if v.IsSet("backups") {
+		backups = v.GetInt("backups")
+	}
+
+	workers := v.GetInt("workers")
+	if workers < 0 {
+		return nil, fmt.Errorf("workers must be 0 or more, got %d", workers)
+	}
+
+	var books []Book
+	if err := v.UnmarshalKey("books", &books); err != nil {
+		return nil, fmt.Errorf("reading books: %w", err)
+	}
+
+	exportFormat := v.GetString("export_format")
+	if exportFormat == "" {
+		exportFormat = "markdown"
+	}
+
+	// Template paths are relative to the config file
+	configDir := filepath.Dir(v.ConfigFileUsed())
+
 	return &Config{
 		NotesDirectory: notesDirectory,
 		HomeDir:        homeDir,
+		ExportFormat:   exportFormat,
+		ExportFile:     v.GetString("export_file"),
+		Merge:          v.GetString("merge"),
+		ManagedRegions: v.GetBool("managed_regions"),
+		Mirror:         v.GetBool("mirror"),
+		Backups:        backups,
+		OnError:        v.GetString("on_error"),
+		Filenames:      v.GetString("filenames"),
+		Workers:        workers,
+		Templates: Templates{
+			Header:    resolvePath(configDir, v.GetString("templates.header")),
+			Highlight: resolvePath(configDir, v.GetString("templates.highlight")),
+			Footer:    resolvePath(configDir, v.GetString("templates.footer")),
+			Extension: v.GetString("templates.extension"),
+		},
+		FrontMatter: FrontMatter{
+			Enabled: v.GetBool("front_matter.enabled"),
+			Source:  source,
+			Tags:    v.GetStringSlice("front_matter.tags"),
+		},
+		Obsidian: Obsidian{
+			Index:    v.GetString("obsidian.index"),
+			Callouts: v.GetBool("obsidian.callouts"),
+		},
+		Anki: Anki{
+			Deck:  deck,
+			Cloze: v.GetBool("anki.cloze"),
+		},
+		Zettelkasten: Zettelkasten{
+			Directory: zettelDirectory,
+		},
+		Books: books,
+	}, nil
+}
+
+func resolvePath(dir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
 	}
+	return filepath.Join(dir, path)
 }
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadFileWorkers(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected int
+		err      string
+	}{
+		{name: "unset uses one per CPU", content: "", expected: 0},
+		{name: "set", content: "workers = 4\n", expected: 4},
+		{name: "negative", content: "workers = -1\n", err: "workers must be 0 or more, got -1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0644))
+
+			cfg, err := LoadFile(path)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err, "Should load without error")
+			assert.Equal(t, tt.expected, cfg.Workers)
+		})
+	}
+}
@@ -0,0 +1,433 @@
+package exporter
+
+import (
+	"archive/zip"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/parser"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+
+	// Registers the pure Go "sqlite" driver used to build .apkg collections
+	_ "modernc.org/sqlite"
+)
+
+const (
+	ankiExtension = ".txt"
+	apkgExtension = ".apkg"
+	ankiTag       = "kindle"
+
+	ankiBasicModel = "Kindle Highlight"
+	ankiClozeModel = "Kindle Highlight Cloze"
+
+	// ankiFieldSeparator joins note fields in the collection database
+	ankiFieldSeparator = "\x1f"
+)
+
+var ankiHTMLTag = regexp.MustCompile(`<[^>]*>`)
+
+// AnkiOptions configures the Anki writers.
+type AnkiOptions struct {
+	Deck  string
+	Cloze bool
+}
+
+// ankiNote is one card's worth of fields, already escaped as HTML.
+type ankiNote struct {
+	guid   string
+	fields []string
+	tags   []string
+}
+
+// WriteAnkiText writes highlights as a tab-separated file for Anki's
+// File > Import, with the quote on the front and the note, book, author and
+// location on the back. With opts.Cloze the longest word of each quote is
+// hidden instead.
+func WriteAnkiText(w io.Writer, highlights []models.Highlight, opts AnkiOptions) error {
+	notetype := "Basic"
+	if opts.Cloze {
+		notetype = "Cloze"
+	}
+
+	var content strings.Builder
+	content.WriteString("#separator:tab\n")
+	content.WriteString("#html:true\n")
+	fmt.Fprintf(&content, "#notetype:%s\n", notetype)
+	if opts.Deck != "" {
+		fmt.Fprintf(&content, "#deck:%s\n", opts.Deck)
+	}
+	content.WriteString("#guid column:1\n")
+	content.WriteString("#tags column:4\n")
+
+	for _, note := range ankiNotes(highlights, opts) {
+		fmt.Fprintf(&content, "%s\t%s\t%s\n", note.guid, strings.Join(note.fields, "\t"), strings.Join(note.tags, " "))
+	}
+
+	_, err := io.WriteString(w, content.String())
+	return err
+}
+
+func ankiNotes(highlights []models.Highlight, opts AnkiOptions) []ankiNote {
+	notes := make([]ankiNote, 0, len(highlights))
+
+	for _, highlight := range highlights {
+		front := ankiField(highlight.Text)
+		if opts.Cloze {
+			front = clozeText(highlight.Text)
+		}
+
+		back := ankiAttribution(highlight)
+		if highlight.Note != "" {
+			back = ankiField(highlight.Note) + "<br><br>" + back
+		}
+
+		tags := []string{ankiTag}
+		if slug := slugify(highlight.Title); slug != "" {
+			tags = append(tags, slug)
+		}
+
+		notes = append(notes, ankiNote{
+			guid:   highlight.ID(),
+			fields: []string{front, back},
+			tags:   tags,
+		})
+	}
+
+	return notes
+}
+
+// ankiField escapes text for an HTML field, keeping line breaks and dropping
+// tabs that would split the row.
+func ankiField(text string) string {
+	text = strings.ReplaceAll(text, "\t", " ")
+	text = html.EscapeString(text)
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
+
+func ankiAttribution(highlight models.Highlight) string {
+	attribution := "<i>" + ankiField(highlight.Title) + "</i>"
+	if highlight.Author != "" {
+		attribution += " — " + ankiField(highlight.Author)
+	}
+
+	var position []string
+	if highlight.Page != "" {
+		position = append(position, "page "+ankiField(highlight.Page))
+	}
+	if highlight.Location != "" {
+		position = append(position, "location "+ankiField(highlight.Location))
+	}
+	if len(position) > 0 {
+		attribution += ", " + strings.Join(position, ", ")
+	}
+
+	return attribution
+}
+
+// clozeText hides the longest word of text as {{c1::...}}, or the whole text
+// when it has no words.
+func clozeText(text string) string {
+	start, end := 0, 0
+	longest := 0
+
+	for i := 0; i < len(text); {
+		wordStart := strings.IndexFunc(text[i:], isWordRune)
+		if wordStart < 0 {
+			break
+		}
+		wordStart += i
+
+		wordEnd := strings.IndexFunc(text[wordStart:], func(r rune) bool { return !isWordRune(r) })
+		if wordEnd < 0 {
+			wordEnd = len(text)
+		} else {
+			wordEnd += wordStart
+		}
+
+		if length := len([]rune(text[wordStart:wordEnd])); length > longest {
+			start, end, longest = wordStart, wordEnd, length
+		}
+		i = wordEnd
+	}
+
+	if longest == 0 {
+		return "{{c1::" + ankiField(text) + "}}"
+	}
+
+	return ankiField(text[:start]) + "{{c1::" + ankiField(text[start:end]) + "}}" + ankiField(text[end:])
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\''
+}
+
+// WriteAnkiPackage writes highlights as an Anki .apkg package: a collection
+// database holding the deck, its note type and one card per highlight,
+// zipped with an empty media map.
+func WriteAnkiPackage(w io.Writer, highlights []models.Highlight, opts AnkiOptions) error {
+	dir, err := os.MkdirTemp("", "kindle-highlights-apkg")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	collection := filepath.Join(dir, "collection.anki2")
+	if err := writeAnkiCollection(collection, highlights, opts); err != nil {
+		return fmt.Errorf("building collection: %w", err)
+	}
+
+	data, err := os.ReadFile(collection)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+	for _, file := range []struct {
+		name    string
+		content []byte
+	}{
+		{name: "collection.anki2", content: data},
+		{name: "media", content: []byte("{}")},
+	} {
+		entry, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := entry.Write(file.content); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func writeAnkiCollection(path string, highlights []models.Highlight, opts AnkiOptions) error {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(ankiSchema); err != nil {
+		return err
+	}
+
+	// Timestamps and IDs come from the content, so exporting the same
+	// selection twice produces the same cards
+	modified := lastAdded(highlights)
+	deckID := ankiID("deck:" + opts.Deck)
+	modelName, model := ankiBasicModel, ankiBasicNoteType
+	if opts.Cloze {
+		modelName, model = ankiClozeModel, ankiClozeNoteType
+	}
+	modelID := ankiID("model:" + modelName)
+
+	col, err := ankiCollectionColumns(modified, deckID, opts.Deck, modelID, modelName, model)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO col VALUES (1, ?, ?, ?, 11, 0, 0, 0, ?, ?, ?, ?, '{}')`,
+		modified.Unix(), modified.UnixMilli(), modified.UnixMilli(), col.conf, col.models, col.decks, col.dconf); err != nil {
+		return err
+	}
+
+	for i, note := range ankiNotes(highlights, opts) {
+		noteID := ankiID("note:" + note.guid)
+		sortField := ankiHTMLTag.ReplaceAllString(note.fields[0], "")
+
+		if _, err := tx.Exec(`INSERT INTO notes VALUES (?, ?, ?, ?, -1, ?, ?, ?, ?, 0, '')`,
+			noteID, note.guid, modelID, modified.Unix(), " "+strings.Join(note.tags, " ")+" ",
+			strings.Join(note.fields, ankiFieldSeparator), sortField, ankiChecksum(sortField)); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`INSERT INTO cards VALUES (?, ?, ?, 0, ?, -1, 0, 0, ?, 0, 0, 0, 0, 0, 0, 0, 0, '')`,
+			ankiID("card:"+note.guid), noteID, deckID, modified.Unix(), i+1); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ankiID derives a positive 48-bit ID from key, small enough for Anki's
+// JavaScript side to handle.
+func ankiID(key string) int64 {
+	sum := sha1.Sum([]byte(key))
+	return int64(binary.BigEndian.Uint64(sum[:8]) >> 16)
+}
+
+// ankiChecksum matches Anki's duplicate check: the first 8 hex digits of the
+// SHA-1 of the sort field.
+func ankiChecksum(field string) int64 {
+	sum := sha1.Sum([]byte(field))
+	checksum, _ := strconv.ParseInt(hex.EncodeToString(sum[:4]), 16, 64)
+	return checksum
+}
+
+// lastAdded returns the latest highlight date, or the Unix epoch when no
+// highlight has one.
+func lastAdded(highlights []models.Highlight) time.Time {
+	var latest time.Time
+	for _, highlight := range highlights {
+		if added, err := parser.ParseDate(highlight.Date); err == nil && added.After(latest) {
+			latest = added
+		}
+	}
+
+	if latest.IsZero() {
+		return time.Unix(0, 0)
+	}
+	return latest
+}
+
+type ankiColumns struct {
+	conf, models, decks, dconf string
+}
+
+func ankiCollectionColumns(modified time.Time, deckID int64, deckName string, modelID int64, modelName string, noteType map[string]any) (ankiColumns, error) {
+	model := make(map[string]any, len(noteType))
+	for key, value := range noteType {
+		model[key] = value
+	}
+	model["id"] = modelID
+	model["name"] = modelName
+	model["did"] = deckID
+	model["mod"] = modified.Unix()
+	model["usn"] = -1
+	model["sortf"] = 0
+	model["tags"] = []string{}
+	model["vers"] = []int{}
+	model["css"] = ankiCSS
+	model["latexPre"] = ankiLatexPre
+	model["latexPost"] = "\\end{document}"
+
+	deck := func(id int64, name string) map[string]any {
+		return map[string]any{
+			"id": id, "name": name, "desc": "", "conf": 1, "dyn": 0, "collapsed": false,
+			"extendNew": 10, "extendRev": 50, "mod": modified.Unix(), "usn": -1,
+			"newToday": []int{0, 0}, "revToday": []int{0, 0}, "lrnToday": []int{0, 0}, "timeToday": []int{0, 0},
+		}
+	}
+
+	values := []any{
+		map[string]any{
+			"activeDecks": []int64{deckID}, "curDeck": deckID, "curModel": strconv.FormatInt(modelID, 10),
+			"newSpread": 0, "collapseTime": 1200, "timeLim": 0, "estTimes": true, "dueCounts": true,
+			"nextPos": 1, "sortType": "noteFld", "sortBackwards": false, "addToCur": true,
+		},
+		map[string]any{strconv.FormatInt(modelID, 10): model},
+		map[string]any{"1": deck(1, "Default"), strconv.FormatInt(deckID, 10): deck(deckID, deckName)},
+		map[string]any{"1": ankiDeckConfig},
+	}
+
+	encoded := make([]string, len(values))
+	for i, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return ankiColumns{}, err
+		}
+		encoded[i] = string(data)
+	}
+
+	return ankiColumns{conf: encoded[0], models: encoded[1], decks: encoded[2], dconf: encoded[3]}, nil
+}
+
+func ankiNoteField(name string, ord int) map[string]any {
+	return map[string]any{"name": name, "ord": ord, "sticky": false, "rtl": false, "font": "Arial", "size": 20, "media": []string{}}
+}
+
+var ankiBasicNoteType = map[string]any{
+	"type": 0,
+	"flds": []map[string]any{ankiNoteField("Front", 0), ankiNoteField("Back", 1)},
+	"tmpls": []map[string]any{{
+		"name": "Card 1", "ord": 0, "did": nil, "bqfmt": "", "bafmt": "",
+		"qfmt": "{{Front}}",
+		"afmt": "{{FrontSide}}<hr id=answer>{{Back}}",
+	}},
+	"req": []any{[]any{0, "any", []int{0}}},
+}
+
+var ankiClozeNoteType = map[string]any{
+	"type": 1,
+	"flds": []map[string]any{ankiNoteField("Text", 0), ankiNoteField("Back Extra", 1)},
+	"tmpls": []map[string]any{{
+		"name": "Cloze", "ord": 0, "did": nil, "bqfmt": "", "bafmt": "",
+		"qfmt": "{{cloze:Text}}",
+		"afmt": "{{cloze:Text}}<br>{{Back Extra}}",
+	}},
+}
+
+var ankiDeckConfig = map[string]any{
+	"id": 1, "name": "Default", "mod": 0, "usn": 0, "maxTaken": 60, "timer": 0, "autoplay": true, "replayq": true,
+	"new": map[string]any{
+		"bury": true, "delays": []int{1, 10}, "initialFactor": 2500, "ints": []int{1, 4, 7},
+		"order": 1, "perDay": 20, "separate": true,
+	},
+	"rev": map[string]any{
+		"bury": true, "ease4": 1.3, "fuzz": 0.05, "ivlFct": 1, "maxIvl": 36500, "minSpace": 1, "perDay": 100,
+	},
+	"lapse": map[string]any{
+		"delays": []int{10}, "leechAction": 0, "leechFails": 8, "minInt": 1, "mult": 0,
+	},
+}
+
+const ankiCSS = `.card { font-family: serif; font-size: 20px; text-align: left; color: black; background-color: white; }
+.cloze { font-weight: bold; color: blue; }`
+
+const ankiLatexPre = "\\documentclass[12pt]{article}\n\\special{papersize=3in,5in}\n\\usepackage[utf8]{inputenc}\n" +
+	"\\usepackage{amssymb,amsmath}\n\\pagestyle{empty}\n\\setlength{\\parindent}{0in}\n\\begin{document}\n"
+
+// ankiSchema is the version 11 collection schema understood by every Anki
+// release that imports .apkg files.
+const ankiSchema = `
+CREATE TABLE col (
+	id integer PRIMARY KEY, crt integer NOT NULL, mod integer NOT NULL, scm integer NOT NULL,
+	ver integer NOT NULL, dty integer NOT NULL, usn integer NOT NULL, ls integer NOT NULL,
+	conf text NOT NULL, models text NOT NULL, decks text NOT NULL, dconf text NOT NULL, tags text NOT NULL
+);
+CREATE TABLE notes (
+	id integer PRIMARY KEY, guid text NOT NULL, mid integer NOT NULL, mod integer NOT NULL,
+	usn integer NOT NULL, tags text NOT NULL, flds text NOT NULL, sfld integer NOT NULL,
+	csum integer NOT NULL, flags integer NOT NULL, data text NOT NULL
+);
+CREATE TABLE cards (
+	id integer PRIMARY KEY, nid integer NOT NULL, did integer NOT NULL, ord integer NOT NULL,
+	mod integer NOT NULL, usn integer NOT NULL, type integer NOT NULL, queue integer NOT NULL,
+	due integer NOT NULL, ivl integer NOT NULL, factor integer NOT NULL, reps integer NOT NULL,
+	lapses integer NOT NULL, left integer NOT NULL, odue integer NOT NULL, odid integer NOT NULL,
+	flags integer NOT NULL, data text NOT NULL
+);
+CREATE TABLE revlog (
+	id integer PRIMARY KEY, cid integer NOT NULL, usn integer NOT NULL, ease integer NOT NULL,
+	ivl integer NOT NULL, lastIvl integer NOT NULL, factor integer NOT NULL, time integer NOT NULL,
+	type integer NOT NULL
+);
+CREATE TABLE graves (usn integer NOT NULL, oid integer NOT NULL, type integer NOT NULL);
+CREATE INDEX ix_notes_usn ON notes (usn);
+CREATE INDEX ix_cards_usn ON cards (usn);
+CREATE INDEX ix_revlog_usn ON revlog (usn);
+CREATE INDEX ix_cards_nid ON cards (nid);
+CREATE INDEX ix_cards_sched ON cards (did, queue, due);
+CREATE INDEX ix_revlog_cid ON revlog (cid);
+CREATE INDEX ix_notes_csum ON notes (csum);
+`
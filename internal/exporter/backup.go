@@ -0,0 +1,255 @@
+package exporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	backupDirectory = "backups"
+	backupIndexFile = "index.json"
+	backupIDFormat  = "20060102T150405Z"
+)
+
+// ErrNoBackups is returned by Restore when there is no export run to roll
+// back.
+var ErrNoBackups = errors.New("no export runs to restore")
+
+// backupIndex lists the export runs with backups, oldest first.
+type backupIndex struct {
+	Runs []backupRun `json:"runs"`
+}
+
+// backupRun is one export run: every file it modified, with a copy of the
+// file as it was, and every file it created.
+type backupRun struct {
+	ID    string         `json:"id"`
+	Time  time.Time      `json:"time"`
+	Files []backedUpFile `json:"files"`
+
+	copies int // Backups copied so far, numbering the next one
+}
+
+type backedUpFile struct {
+	Path    string `json:"path"`
+	Backup  string `json:"backup,omitempty"` // Copy of the original, relative to the run's directory
+	Created bool   `json:"created,omitempty"`
+}
+
+// RestoreResult describes the export run rolled back by Restore.
+type RestoreResult struct {
+	RunID    string
+	RunTime  time.Time
+	Restored []string // Files put back as they were before the run
+	Removed  []string // Files the run had created
+}
+
+func (s *Service) backupRoot() string {
+	return filepath.Join(s.notesRoot(), stateDirectory, backupDirectory)
+}
+
+// startBackupRun begins recording the files an export modifies, unless
+// backups are turned off.
+func (s *Service) startBackupRun() error {
+	s.backup = nil
+	if s.config.Backups <= 0 {
+		return nil
+	}
+
+	index, err := s.loadBackupIndex()
+	if err != nil {
+		return err
+	}
+
+	// Runs within the same second get a suffix, keeping their backups apart
+	now := s.now().UTC()
+	id := now.Format(backupIDFormat)
+	for n := 2; index.has(id); n++ {
+		id = fmt.Sprintf("%s-%d", now.Format(backupIDFormat), n)
+	}
+
+	s.backup = &backupRun{ID: id, Time: now}
+	return nil
+}
+
+func (index *backupIndex) has(id string) bool {
+	for _, run := range index.Runs {
+		if run.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// backupFile saves a copy of filename before the export first overwrites
+// it, or notes that the export created it.
+func (s *Service) backupFile(filename string) error {
+	run := s.backup
+	if run == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	backedUp := run.has(filename)
+	s.mu.Unlock()
+	if backedUp {
+		return nil
+	}
+
+	original, err := s.fs.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		s.addBackup(run, backedUpFile{Path: filename, Created: true})
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s for backup: %w", filename, err)
+	}
+
+	s.mu.Lock()
+	run.copies++
+	backup := fmt.Sprintf("%03d-%s", run.copies, filepath.Base(filename))
+	s.mu.Unlock()
+
+	path := filepath.Join(s.backupRoot(), run.ID, backup)
+	if err := s.fs.MkdirAll(filepath.Dir(path), dirPermissions); err != nil {
+		return fmt.Errorf("creating backup directory: %w", err)
+	}
+	if err := s.fs.WriteFile(path, original, filePermissions); err != nil {
+		return fmt.Errorf("backing up %s: %w", filename, err)
+	}
+
+	s.addBackup(run, backedUpFile{Path: filename, Backup: backup})
+	return nil
+}
+
+func (s *Service) addBackup(run *backupRun, file backedUpFile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run.Files = append(run.Files, file)
+}
+
+func (run *backupRun) has(filename string) bool {
+	for _, file := range run.Files {
+		if file.Path == filename {
+			return true
+		}
+	}
+	return false
+}
+
+// finishBackupRun adds the run to the index and drops the oldest runs beyond
+// the configured number.
+func (s *Service) finishBackupRun() error {
+	run := s.backup
+	s.backup = nil
+	if run == nil || len(run.Files) == 0 {
+		return nil
+	}
+
+	index, err := s.loadBackupIndex()
+	if err != nil {
+		return err
+	}
+
+	index.Runs = append(index.Runs, *run)
+
+	for len(index.Runs) > s.config.Backups {
+		if err := s.fs.RemoveAll(filepath.Join(s.backupRoot(), index.Runs[0].ID)); err != nil {
+			return fmt.Errorf("removing old backup: %w", err)
+		}
+		index.Runs = index.Runs[1:]
+	}
+
+	return s.saveBackupIndex(index)
+}
+
+// discardBackupRun drops the backups of a run whose writes were reverted.
+func (s *Service) discardBackupRun() {
+	if s.backup != nil {
+		s.fs.RemoveAll(filepath.Join(s.backupRoot(), s.backup.ID))
+	}
+	s.backup = nil
+}
+
+// Restore rolls back the most recent export run: modified files are put back
+// from their backups and files it created are removed. Calling it again
+// rolls back the run before that.
+func (s *Service) Restore() (RestoreResult, error) {
+	index, err := s.loadBackupIndex()
+	if err != nil {
+		return RestoreResult{}, err
+	}
+	if len(index.Runs) == 0 {
+		return RestoreResult{}, ErrNoBackups
+	}
+
+	run := index.Runs[len(index.Runs)-1]
+	result := RestoreResult{RunID: run.ID, RunTime: run.Time}
+	runDirectory := filepath.Join(s.backupRoot(), run.ID)
+
+	for _, file := range run.Files {
+		if file.Created {
+			if err := s.fs.Remove(file.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return result, fmt.Errorf("removing %s: %w", file.Path, err)
+			}
+			result.Removed = append(result.Removed, file.Path)
+			continue
+		}
+
+		original, err := s.fs.ReadFile(filepath.Join(runDirectory, file.Backup))
+		if err != nil {
+			return result, fmt.Errorf("reading backup of %s: %w", file.Path, err)
+		}
+		if err := s.ensureDirectoryExists(file.Path); err != nil {
+			return result, fmt.Errorf("creating directory: %w", err)
+		}
+		if err := s.fs.WriteFile(file.Path, original, filePermissions); err != nil {
+			return result, fmt.Errorf("restoring %s: %w", file.Path, err)
+		}
+		result.Restored = append(result.Restored, file.Path)
+	}
+
+	if err := s.fs.RemoveAll(runDirectory); err != nil {
+		return result, fmt.Errorf("removing backup: %w", err)
+	}
+	index.Runs = index.Runs[:len(index.Runs)-1]
+
+	return result, s.saveBackupIndex(index)
+}
+
+func (s *Service) loadBackupIndex() (*backupIndex, error) {
+	index := &backupIndex{}
+
+	data, err := s.fs.ReadFile(filepath.Join(s.backupRoot(), backupIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading backup index: %w", err)
+	}
+
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("parsing backup index: %w", err)
+	}
+	return index, nil
+}
+
+func (s *Service) saveBackupIndex(index *backupIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding backup index: %w", err)
+	}
+
+	path := filepath.Join(s.backupRoot(), backupIndexFile)
+	if err := s.fs.MkdirAll(filepath.Dir(path), dirPermissions); err != nil {
+		return fmt.Errorf("creating backup directory: %w", err)
+	}
+	if err := s.fs.WriteFile(path, append(data, '\n'), filePermissions); err != nil {
+		return fmt.Errorf("writing backup index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package exporter
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const diffContext = 3
+
+// FileChange is a file an export would create, modify or delete.
+type FileChange struct {
+	Path    string
+	Created bool
+	Deleted bool
+	Before  string
+	After   string
+}
+
+// Diff returns the change as a unified diff.
+func (c FileChange) Diff() string {
+	from, to := c.Path, c.Path
+	if c.Created {
+		from = "/dev/null"
+	}
+	if c.Deleted {
+		to = "/dev/null"
+	}
+
+	if isBinary(c.Before) || isBinary(c.After) {
+		return "Binary files " + from + " and " + to + " differ\n"
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(c.Before),
+		B:        splitLines(c.After),
+		FromFile: from,
+		ToFile:   to,
+		Context:  diffContext,
+	})
+	if err != nil {
+		return "Files " + from + " and " + to + " differ\n"
+	}
+	return diff
+}
+
+// splitLines splits content after each newline, ending the last line with
+// one when it has none.
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n"
+	return lines
+}
+
+func isBinary(content string) bool {
+	return !utf8.ValidString(content) || strings.IndexByte(content, 0) >= 0
+}
+
+// Preview is the outcome of a dry run: the results the export would report
+// and every file it would change.
+type Preview struct {
+	Results []models.ExportResult
+	Changes []FileChange
+}
+
+// Counts returns how many files the export would create, modify and
+// delete.
+func (p Preview) Counts() (created, modified, deleted int) {
+	for _, change := range p.Changes {
+		switch {
+		case change.Created:
+			created++
+		case change.Deleted:
+			deleted++
+		default:
+			modified++
+		}
+	}
+	return created, modified, deleted
+}
+
+// Removed returns how many highlights the export would remove.
+func (p Preview) Removed() int {
+	removed := 0
+	for _, result := range p.Results {
+		removed += result.RemovedCount
+	}
+	return removed
+}
+
+// DryRun runs the whole export against the service's file system without
+// writing anything, returning what would change. Exports streamed to stdout
+// are discarded.
+func (s *Service) DryRun(bookHighlights map[string][]models.Highlight) (Preview, error) {
+	recorder := newStagedFileSystem(s.fs)
+
+	// Nothing is written, so there is nothing to back up
+	cfg := *s.config
+	cfg.Backups = 0
+
+	dryRun := NewWithFileSystem(&cfg, recorder)
+	dryRun.SetStdout(io.Discard)
+	dryRun.source = s.source
+
+	results, err := dryRun.ExportHighlights(bookHighlights)
+
+	// The sync state is bookkeeping, not a change to the notes
+	changes := slices.DeleteFunc(recorder.changes(), func(change FileChange) bool {
+		return change.Path == s.statePath()
+	})
+	return Preview{Results: results, Changes: changes}, err
+}
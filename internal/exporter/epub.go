@@ -0,0 +1,206 @@
+package exporter
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha1"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/parser"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const (
+	epubExtension      = ".epub"
+	epubMimetype       = "application/epub+zip"
+	epubTitle          = "Highlights"
+	epubModifiedFormat = "2006-01-02T15:04:05Z"
+)
+
+type epubChapter struct {
+	models.BookGroup
+	File string
+}
+
+type epubPackage struct {
+	Identifier string
+	Title      string
+	Modified   string
+	Chapters   []epubChapter
+}
+
+// epubTemplates are text templates, as html/template would escape the XML
+// declarations; every field goes through xml instead.
+var epubTemplates = template.Must(template.New("epub").Funcs(template.FuncMap{
+	"highlightID": func(highlight models.Highlight) string { return highlight.ID() },
+	"xml":         escapeXML,
+}).Parse(epubTemplate))
+
+func escapeXML(text string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
+
+// WriteEPUB writes highlights as an EPUB 3 "commonplace book" with one
+// chapter per book and each highlight as a blockquote.
+func WriteEPUB(w io.Writer, highlights []models.Highlight) error {
+	pkg := epubPackage{Title: epubTitle}
+
+	identity := sha1.New()
+	var modified time.Time
+	for i, book := range groupBooks(highlights) {
+		pkg.Chapters = append(pkg.Chapters, epubChapter{
+			BookGroup: book,
+			File:      fmt.Sprintf("chapter-%03d.xhtml", i+1),
+		})
+
+		for _, highlight := range book.Highlights {
+			identity.Write([]byte(highlight.ID()))
+			if added, err := parser.ParseDate(highlight.Date); err == nil && added.After(modified) {
+				modified = added
+			}
+		}
+	}
+
+	// Derive the identifier and timestamp from the content, so exporting
+	// the same selection twice produces the same file
+	sum := identity.Sum(nil)
+	pkg.Identifier = fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+	if modified.IsZero() {
+		modified = time.Unix(0, 0)
+	}
+	pkg.Modified = modified.UTC().Format(epubModifiedFormat)
+
+	archive := zip.NewWriter(w)
+
+	// The mimetype must come first and be stored uncompressed
+	if err := writeStoredFile(archive, "mimetype", []byte(epubMimetype)); err != nil {
+		return err
+	}
+
+	files := []struct {
+		name     string
+		template string
+		data     any
+	}{
+		{name: "META-INF/container.xml", template: "container", data: pkg},
+		{name: "OEBPS/content.opf", template: "opf", data: pkg},
+		{name: "OEBPS/nav.xhtml", template: "nav", data: pkg},
+		{name: "OEBPS/style.css", template: "style", data: pkg},
+	}
+	for _, chapter := range pkg.Chapters {
+		files = append(files, struct {
+			name     string
+			template string
+			data     any
+		}{name: "OEBPS/" + chapter.File, template: "chapter", data: chapter})
+	}
+
+	for _, file := range files {
+		entry, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if err := epubTemplates.ExecuteTemplate(entry, file.template, file.data); err != nil {
+			return fmt.Errorf("rendering %s: %w", file.name, err)
+		}
+	}
+
+	return archive.Close()
+}
+
+func writeStoredFile(archive *zip.Writer, name string, content []byte) error {
+	entry, err := archive.CreateRaw(&zip.FileHeader{
+		Name:               name,
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(content),
+		CompressedSize64:   uint64(len(content)),
+		UncompressedSize64: uint64(len(content)),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(entry, bytes.NewReader(content))
+	return err
+}
+
+const epubTemplate = `
+{{define "container"}}<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+{{end}}
+
+{{define "opf"}}<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">{{.Identifier | xml}}</dc:identifier>
+    <dc:title>{{.Title | xml}}</dc:title>
+    <dc:language>en</dc:language>
+    <meta property="dcterms:modified">{{.Modified | xml}}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+{{range $i, $chapter := .Chapters}}    <item id="chapter-{{$i}}" href="{{$chapter.File | xml}}" media-type="application/xhtml+xml"/>
+{{end}}  </manifest>
+  <spine>
+{{range $i, $chapter := .Chapters}}    <itemref idref="chapter-{{$i}}"/>
+{{end}}  </spine>
+</package>
+{{end}}
+
+{{define "nav"}}<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="en">
+<head>
+  <title>{{.Title | xml}}</title>
+</head>
+<body>
+  <nav epub:type="toc" id="toc">
+    <h1>{{.Title | xml}}</h1>
+    <ol>
+{{range .Chapters}}      <li><a href="{{.File | xml}}">{{.Title | xml}}</a></li>
+{{end}}    </ol>
+  </nav>
+</body>
+</html>
+{{end}}
+
+{{define "style"}}body { font-family: serif; line-height: 1.5; }
+h1 { margin-bottom: 0.2em; }
+.author { margin-top: 0; color: #555; }
+blockquote { margin: 1.5em 0; padding-left: 1em; border-left: 0.2em solid #999; }
+.note { font-style: italic; }
+.meta { font-size: 0.8em; color: #777; }
+{{end}}
+
+{{define "chapter"}}<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" lang="en">
+<head>
+  <title>{{.Title | xml}}</title>
+  <link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+  <h1>{{.Title | xml}}</h1>
+  <p class="author">{{.Author | xml}}</p>
+{{range .Highlights}}  <blockquote id="h-{{highlightID .}}">
+    <p>{{.Text | xml}}</p>
+{{if .Note}}    <p class="note">{{.Note | xml}}</p>
+{{end}}    <p class="meta">{{if .Page}}Page {{.Page | xml}} · {{end}}Location {{.Location | xml}}{{if .Date}} · {{.Date | xml}}{{end}}</p>
+  </blockquote>
+{{end}}</body>
+</html>
+{{end}}
+`
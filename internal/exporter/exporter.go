@@ -2,11 +2,19 @@ package exporter
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/matthewrobinsdev/kindle-notes-parser/internal/config"
 	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
@@ -21,6 +29,9 @@ const (
 	headerFormat      = "# %s\n\n"
 	keySeparator      = "|"
 
+	// StdoutPath as export_file streams single-file formats to stdout
+	StdoutPath = "-"
+
 	// Regex pattern for parsing existing highlights
 	highlightPattern = `^- (.+) \(Page: (\d+)\)$`
 )
@@ -30,6 +41,9 @@ type FileSystem interface {
 	ReadFile(filename string) ([]byte, error)
 	WriteFile(filename string, data []byte, perm os.FileMode) error
 	MkdirAll(path string, perm os.FileMode) error
+	ReadDir(name string) ([]os.DirEntry, error)
+	Remove(name string) error
+	RemoveAll(path string) error
 }
 
 type OSFileSystem struct{}
@@ -42,18 +56,78 @@ func (fs OSFileSystem) ReadFile(filename string) ([]byte, error) {
 	return os.ReadFile(filename)
 }
 
+// WriteFile replaces filename atomically: the data goes to a temporary file
+// in the same directory, is synced to disk and then renamed over filename,
+// so a crash or full disk never leaves a truncated note behind. An existing
+// file keeps its permissions; perm only applies to new files.
 func (fs OSFileSystem) WriteFile(filename string, data []byte, perm os.FileMode) error {
-	return os.WriteFile(filename, data, perm)
+	dir := filepath.Dir(filename)
+	if info, err := os.Stat(filename); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	temp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tempName := temp.Name()
+	defer os.Remove(tempName) // No-op once renamed
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tempName, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tempName, filename); err != nil {
+		return err
+	}
+
+	// Persist the rename itself; not every platform can sync a directory
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
 }
 
 func (fs OSFileSystem) MkdirAll(path string, perm os.FileMode) error {
 	return os.MkdirAll(path, perm)
 }
 
+func (fs OSFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (fs OSFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (fs OSFileSystem) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
 type Service struct {
 	config      *config.Config
 	fs          FileSystem
+	stdout      io.Writer
+	source      map[string]bool // IDs of every highlight in the clippings, when known
 	highlightRe *regexp.Regexp
+	renderer    bookRenderer
+	state       *syncState
+	backup      *backupRun
+	claimed     map[string]string // Lowercased note paths to the ID of the book exported there
+	now         func() time.Time
+	mu          sync.Mutex // Guards claimed and the files of the backup run while books export concurrently
 }
 
 func New(cfg *config.Config) *Service {
@@ -64,69 +138,484 @@ func NewWithFileSystem(cfg *config.Config, fs FileSystem) *Service {
 	return &Service{
 		config:      cfg,
 		fs:          fs,
+		stdout:      os.Stdout,
 		highlightRe: regexp.MustCompile(highlightPattern),
+		now:         time.Now,
+	}
+}
+
+// SetStdout changes where exports to StdoutPath are written.
+func (s *Service) SetStdout(w io.Writer) {
+	s.stdout = w
+}
+
+// SetSource gives the service every highlight in the clippings, not just
+// the selection being exported. Mirroring then only removes highlights that
+// are gone from the clippings, rather than every one left out of the
+// selection.
+func (s *Service) SetSource(highlights []models.Highlight) {
+	s.source = make(map[string]bool, len(highlights))
+	for _, highlight := range highlights {
+		s.source[highlight.ID()] = true
 	}
 }
 
+// ExportHighlights writes the highlights in the configured format, backing
+// up every file it modifies so the run can be rolled back with Restore.
+// Books that fail carry their error in their result; the configured error
+// policy decides whether the remaining books are still exported.
 func (s *Service) ExportHighlights(bookHighlights map[string][]models.Highlight) ([]models.ExportResult, error) {
+	bookHighlights = s.withoutIgnored(bookHighlights)
 	if len(bookHighlights) == 0 {
 		return []models.ExportResult{}, nil
 	}
+	if err := s.validateOnError(); err != nil {
+		return []models.ExportResult{}, err
+	}
+	if s.config.OnError == OnErrorRollback {
+		return s.exportStaged(bookHighlights)
+	}
 
-	results := make([]models.ExportResult, 0, len(bookHighlights))
+	if err := s.startBackupRun(); err != nil {
+		return []models.ExportResult{}, err
+	}
+
+	results, err := s.exportHighlights(bookHighlights)
+	if backupErr := s.finishBackupRun(); backupErr != nil && err == nil {
+		err = fmt.Errorf("recording backups: %w", backupErr)
+	}
+
+	return results, err
+}
 
-	for title, highlights := range bookHighlights {
+func (s *Service) exportHighlights(bookHighlights map[string][]models.Highlight) ([]models.ExportResult, error) {
+	format := s.format()
+	if writer, ok := s.singleFileWriter(format); ok {
+		return s.exportSingleFile(bookHighlights, writer)
+	}
+	if format == FormatHTML {
+		return s.exportSite(bookHighlights)
+	}
+	if format != FormatZettel {
+		if _, err := s.bookRenderer(); err != nil {
+			return []models.ExportResult{}, err
+		}
+	}
+	if err := s.validateMerge(); err != nil {
+		return []models.ExportResult{}, err
+	}
+	if err := s.validateFilenames(); err != nil {
+		return []models.ExportResult{}, err
+	}
+	if err := s.validateMirror(); err != nil {
+		return []models.ExportResult{}, err
+	}
+	if s.config.ExportFile == StdoutPath {
+		return []models.ExportResult{}, fmt.Errorf("%s writes one file per book and cannot be streamed to stdout", format)
+	}
+
+	state, err := s.loadState()
+	if err != nil {
+		return []models.ExportResult{}, err
+	}
+	s.state = state
+	s.mu.Lock()
+	s.claimed = make(map[string]string)
+	s.mu.Unlock()
+
+	var results []models.ExportResult
+	if format == FormatZettel {
+		results, err = s.exportZettelkasten(bookHighlights)
+	} else {
+		results, err = s.exportBooks(bookHighlights)
+	}
+
+	// Save the manifest even after a failure, so files already written are
+	// not exported twice
+	if saveErr := s.saveState(state); saveErr != nil && err == nil {
+		err = saveErr
+	}
+
+	return results, err
+}
+
+// exportBooks writes one note per book with the configured renderer.
+func (s *Service) exportBooks(bookHighlights map[string][]models.Highlight) ([]models.ExportResult, error) {
+	renderer, err := s.bookRenderer()
+	if err != nil {
+		return []models.ExportResult{}, err
+	}
+	var subdirectory string
+	if sub, ok := renderer.(subdirectoryRenderer); ok {
+		subdirectory = sub.subdirectory()
+	}
+	s.claimPaths(bookHighlights, renderer.extension(), subdirectory)
+
+	results, err := s.exportEach(bookHighlights, s.exportBookHighlights)
+
+	if s.format() == FormatObsidian && s.config.Obsidian.Index != "" && (err == nil || s.config.OnError == OnErrorContinue) {
+		if indexErr := s.updateObsidianIndex(bookHighlights, results); indexErr != nil {
+			err = errors.Join(err, fmt.Errorf("updating index note: %w", indexErr))
+		}
+	}
+
+	return results, err
+}
+
+// exportEach exports the books on the configured number of workers,
+// returning their results in title order and recording a failure in the
+// book's result. After the first failure no more books are started unless
+// the error policy is to continue, in which case every failure is returned
+// together.
+func (s *Service) exportEach(bookHighlights map[string][]models.Highlight, export func(string, []models.Highlight) (models.ExportResult, error)) ([]models.ExportResult, error) {
+	var titles []string
+	for _, title := range sortedTitles(bookHighlights) {
+		if len(bookHighlights[title]) > 0 { // Skip books with no highlights
+			titles = append(titles, title)
+		}
+	}
+
+	results := make([]models.ExportResult, len(titles))
+	done := make([]bool, len(titles))
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		failed bool
+	)
+	workers := make(chan struct{}, s.workers())
+
+	for i, title := range titles {
+		workers <- struct{}{}
+		mu.Lock()
+		stop := failed && s.config.OnError != OnErrorContinue
+		mu.Unlock()
+		if stop {
+			<-workers
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() { <-workers; wg.Done() }()
+
+			result, err := export(title, bookHighlights[title])
+			if err != nil {
+				result.Err = fmt.Errorf("exporting highlights for %q: %w", title, err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			results[i], done[i] = result, true
+			failed = failed || err != nil
+		}()
+	}
+	wg.Wait()
+
+	exported := results[:0]
+	var errs []error
+	for i, result := range results {
+		if !done[i] {
+			continue
+		}
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+		}
+		exported = append(exported, result)
+	}
+
+	return exported, errors.Join(errs...)
+}
+
+// workers is the number of books exported at once.
+func (s *Service) workers() int {
+	if s.config.Workers > 0 {
+		return s.config.Workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// claimPaths picks the note path of every book in title order before the
+// books are exported concurrently, so which of two colliding books gets
+// the plain name never depends on which finishes first.
+func (s *Service) claimPaths(bookHighlights map[string][]models.Highlight, extension, subdirectory string) {
+	for _, title := range sortedTitles(bookHighlights) {
+		if highlights := bookHighlights[title]; len(highlights) > 0 {
+			s.buildFilePath(newBookGroup(title, highlights), extension, subdirectory)
+		}
+	}
+}
+
+func sortedTitles(bookHighlights map[string][]models.Highlight) []string {
+	titles := make([]string, 0, len(bookHighlights))
+	for title := range bookHighlights {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	return titles
+}
+
+func (s *Service) format() Format {
+	if s.config.ExportFormat == "" {
+		return FormatMarkdown
+	}
+	return Format(strings.ToLower(s.config.ExportFormat))
+}
+
+func (s *Service) exportSingleFile(bookHighlights map[string][]models.Highlight, writer singleFileWriter) ([]models.ExportResult, error) {
+	var all []models.Highlight
+	results := make([]models.ExportResult, 0, len(bookHighlights))
+	for _, title := range sortedTitles(bookHighlights) {
+		highlights := bookHighlights[title]
 		if len(highlights) == 0 {
-			continue // Skip books with no highlights
+			continue
 		}
 
-		result, err := s.exportBookHighlights(title, highlights)
-		if err != nil {
-			return results, fmt.Errorf("exporting highlights for %q: %w", title, err)
+		all = append(all, highlights...)
+		results = append(results, models.ExportResult{
+			BookTitle:  title,
+			NewCount:   len(highlights),
+			TotalCount: len(highlights),
+			WrittenIDs: highlightIDs(highlights),
+		})
+	}
+
+	var content bytes.Buffer
+	if err := writer.write(&content, all); err != nil {
+		return []models.ExportResult{}, fmt.Errorf("rendering export: %w", err)
+	}
+
+	if s.config.ExportFile == StdoutPath {
+		if _, err := content.WriteTo(s.stdout); err != nil {
+			return []models.ExportResult{}, fmt.Errorf("writing to stdout: %w", err)
 		}
-		results = append(results, result)
+		return results, nil
+	}
+
+	filename := s.buildSingleFilePath(writer.extension)
+	for i := range results {
+		results[i].FilePath = filename
+	}
+	if err := s.ensureDirectoryExists(filename); err != nil {
+		return []models.ExportResult{}, fmt.Errorf("creating directory: %w", err)
+	}
+
+	if err := s.writeFile(filename, content.String()); err != nil {
+		return []models.ExportResult{}, fmt.Errorf("writing file: %w", err)
 	}
 
 	return results, nil
 }
 
+// exportBookHighlights adds the new highlights to the book's note. A failed
+// export returns whatever of the result was known by then.
 func (s *Service) exportBookHighlights(title string, highlights []models.Highlight) (models.ExportResult, error) {
+	result := models.ExportResult{BookTitle: title, TotalCount: len(highlights)}
 	if title == "" {
-		return models.ExportResult{}, fmt.Errorf("book title cannot be empty")
+		return result, fmt.Errorf("book title cannot be empty")
 	}
 
-	filename := s.buildFilePath(title)
+	renderer, err := s.bookRenderer()
+	if err != nil {
+		return result, err
+	}
+
+	book := newBookGroup(title, highlights)
+	var subdirectory string
+	if sub, ok := renderer.(subdirectoryRenderer); ok {
+		subdirectory = sub.subdirectory()
+	}
+	filename := s.buildFilePath(book, renderer.extension(), subdirectory)
+	result.FilePath = filename
+
+	bookID, tags := book.ID(), s.bookTags(book)
+	book = s.displayBook(book)
 
 	if err := s.ensureDirectoryExists(filename); err != nil {
-		return models.ExportResult{}, fmt.Errorf("creating directory: %w", err)
+		return result, fmt.Errorf("creating directory: %w", err)
+	}
+
+	existingContent, exists, err := s.loadExistingFile(filename)
+	if err != nil {
+		return result, fmt.Errorf("loading existing file: %w", err)
+	}
+
+	// The manifest decides what was already exported. Files it does not
+	// track yet, e.g. from before it existed, are scanned once instead
+	key := s.stateKey(filename)
+	if !exists {
+		s.state.forget(key)
+	}
+	exported := renderer.existing(existingContent, book)
+	if tracked, ok := s.state.file(key); ok {
+		exported = func(highlight models.Highlight) bool {
+			return tracked.has(highlight.ID())
+		}
+	}
+
+	newHighlights, skippedCount := s.filterDuplicates(highlights, exported)
+
+	var previous []models.Highlight
+	for _, highlight := range highlights {
+		if exported(highlight) {
+			previous = append(previous, highlight)
+		}
+	}
+	result.SkippedIDs = highlightIDs(previous)
+
+	var updatedCount int
+	var conflicts []models.Conflict
+	managed, isManaged := renderer.(managedRenderer)
+	if isManaged && exists {
+		existingContent, updatedCount, conflicts, err = managed.refresh(existingContent, book, previous)
+		if err != nil {
+			return result, fmt.Errorf("refreshing highlights: %w", err)
+		}
+	}
+
+	// Mirroring removes the blocks of highlights this tool wrote that are no
+	// longer in the source
+	var removedIDs []string
+	if tracked, ok := s.state.file(key); ok && s.config.Mirror && isManaged && exists {
+		stale := s.staleIDs(tracked.Highlights, highlights)
+
+		var kept []models.Conflict
+		existingContent, removedIDs, kept = managed.remove(existingContent, stale)
+		conflicts = append(conflicts, kept...)
+
+		for _, conflict := range kept {
+			stale = slices.DeleteFunc(stale, func(id string) bool { return id == conflict.HighlightID })
+		}
+		s.state.drop(key, stale)
+	}
+
+	if len(newHighlights) > 0 || updatedCount > 0 || len(removedIDs) > 0 {
+		content, err := s.renderBook(renderer, book, existingContent, exists, newHighlights, highlights)
+		if err != nil {
+			return result, fmt.Errorf("rendering highlights: %w", err)
+		}
+
+		// YAML front matter only belongs at the top of markdown
+		if s.config.FrontMatter.Enabled && renderer.extension() == markdownExtension {
+			content, err = s.applyFrontMatter(content, book, tags, newHighlights, skippedCount, len(removedIDs))
+			if err != nil {
+				return result, err
+			}
+		}
+
+		if err := s.writeFile(filename, content); err != nil {
+			return result, fmt.Errorf("writing file: %w", err)
+		}
 	}
 
-	existingContent, existingHighlights, err := s.loadExistingFile(filename, title)
+	if len(newHighlights) > 0 || exists {
+		s.state.record(key, bookID, title, highlightIDs(highlights))
+	}
+
+	result.NewCount = len(newHighlights)
+	result.SkippedCount = skippedCount
+	result.UpdatedCount = updatedCount
+	result.RemovedCount = len(removedIDs)
+	result.RemovedIDs = removedIDs
+	result.WrittenIDs = highlightIDs(newHighlights)
+	result.Conflicts = conflicts
+	return result, nil
+}
+
+// renderBook adds highlights to the existing note, or to a fresh header when
+// the note does not exist yet, and re-renders the footer after them. The
+// other highlights of the book place new ones in ordered merges.
+func (s *Service) renderBook(renderer bookRenderer, book models.BookGroup, existingContent string, exists bool, highlights, others []models.Highlight) (string, error) {
+	content := &strings.Builder{}
+
+	body := existingContent
+	if exists {
+		body, _ = splitFooter(existingContent)
+	} else {
+		header, err := renderer.header(book)
+		if err != nil {
+			return "", err
+		}
+		body = header
+	}
+
+	if s.config.Merge == MergeOrdered {
+		merged, err := mergeByLocation(renderer, book, body, others, highlights)
+		if err != nil {
+			return "", err
+		}
+		content.WriteString(merged)
+	} else {
+		content.WriteString(body)
+		for _, highlight := range highlights {
+			rendered, err := renderer.highlight(book, highlight)
+			if err != nil {
+				return "", err
+			}
+			content.WriteString(rendered)
+		}
+	}
+
+	footer, err := renderer.footer(book)
 	if err != nil {
-		return models.ExportResult{}, fmt.Errorf("loading existing file: %w", err)
+		return "", err
+	}
+	if footer != "" {
+		content.WriteString(footerMarker)
+		content.WriteString(footer)
 	}
 
-	newHighlights, skippedCount := s.filterDuplicates(highlights, existingHighlights)
+	return content.String(), nil
+}
 
-	if len(newHighlights) > 0 {
-		s.appendHighlights(existingContent, newHighlights)
+// staleIDs returns the tracked highlight IDs missing from the source, or
+// from the book's highlights when no source was set.
+func (s *Service) staleIDs(tracked []string, highlights []models.Highlight) []string {
+	current := s.source
+	if current == nil {
+		current = make(map[string]bool, len(highlights))
+		for _, highlight := range highlights {
+			current[highlight.ID()] = true
+		}
+	}
 
-		if err := s.writeFile(filename, existingContent.String()); err != nil {
-			return models.ExportResult{}, fmt.Errorf("writing file: %w", err)
+	var stale []string
+	for _, id := range tracked {
+		if !current[id] {
+			stale = append(stale, id)
 		}
 	}
+	return stale
+}
 
-	return models.ExportResult{
-		BookTitle:    title,
-		NewCount:     len(newHighlights),
-		SkippedCount: skippedCount,
-		TotalCount:   len(highlights),
-	}, nil
+func highlightIDs(highlights []models.Highlight) []string {
+	ids := make([]string, 0, len(highlights))
+	for _, highlight := range highlights {
+		ids = append(ids, highlight.ID())
+	}
+	return ids
 }
 
-func (s *Service) buildFilePath(title string) string {
-	sanitizedTitle := s.sanitizeFilename(title)
-	return filepath.Join(s.config.HomeDir, s.config.NotesDirectory, sanitizedTitle+markdownExtension)
+func newBookGroup(title string, highlights []models.Highlight) models.BookGroup {
+	book := models.BookGroup{Title: title, Highlights: highlights}
+	if len(highlights) > 0 {
+		book.Author = highlights[0].Author
+	}
+	return book
+}
+
+// buildSingleFilePath resolves the export_file setting, defaulting to a file
+// in the notes directory. Relative paths are taken from the notes directory.
+func (s *Service) buildSingleFilePath(extension string) string {
+	filename := s.config.ExportFile
+	if filename == "" {
+		filename = defaultExportBasename + extension
+	}
+
+	if filepath.IsAbs(filename) {
+		return filename
+	}
+
+	return filepath.Join(s.config.HomeDir, s.config.NotesDirectory, filename)
 }
 
 func (s *Service) sanitizeFilename(filename string) string {
@@ -148,12 +637,7 @@ func (s *Service) sanitizeFilename(filename string) string {
 		result = strings.ReplaceAll(result, old, new)
 	}
 
-	result = strings.TrimSpace(result)
-	if len(result) > 200 {
-		result = result[:200]
-	}
-
-	return result
+	return truncateName(strings.TrimSpace(result), maxFilenameBytes)
 }
 
 func (s *Service) ensureDirectoryExists(filename string) error {
@@ -161,35 +645,27 @@ func (s *Service) ensureDirectoryExists(filename string) error {
 	return s.fs.MkdirAll(dir, dirPermissions)
 }
 
-func (s *Service) loadExistingFile(filename, title string) (*strings.Builder, map[string]bool, error) {
-	content := &strings.Builder{}
-	var existingHighlights map[string]bool
-
-	if _, err := s.fs.Stat(filename); err == nil {
-		data, err := s.fs.ReadFile(filename)
-		if err != nil {
-			return nil, nil, fmt.Errorf("reading existing file: %w", err)
-		}
-
-		content.Write(data)
-		existingHighlights = s.extractExistingHighlights(string(data))
-
-		return content, existingHighlights, nil
+// loadExistingFile returns the current content of filename and whether it
+// exists.
+func (s *Service) loadExistingFile(filename string) (string, bool, error) {
+	if _, err := s.fs.Stat(filename); err != nil {
+		return "", false, nil
 	}
 
-	content.WriteString(fmt.Sprintf(headerFormat, title))
-	existingHighlights = make(map[string]bool)
+	data, err := s.fs.ReadFile(filename)
+	if err != nil {
+		return "", false, fmt.Errorf("reading existing file: %w", err)
+	}
 
-	return content, existingHighlights, nil
+	return string(data), true, nil
 }
 
-func (s *Service) filterDuplicates(highlights []models.Highlight, existing map[string]bool) ([]models.Highlight, int) {
+func (s *Service) filterDuplicates(highlights []models.Highlight, exists func(models.Highlight) bool) ([]models.Highlight, int) {
 	newHighlights := make([]models.Highlight, 0, len(highlights))
 	skippedCount := 0
 
 	for _, highlight := range highlights {
-		key := s.createHighlightKey(highlight)
-		if !existing[key] {
+		if !exists(highlight) {
 			newHighlights = append(newHighlights, highlight)
 		} else {
 			skippedCount++
@@ -199,17 +675,20 @@ func (s *Service) filterDuplicates(highlights []models.Highlight, existing map[s
 	return newHighlights, skippedCount
 }
 
-func (s *Service) appendHighlights(content *strings.Builder, highlights []models.Highlight) {
-	for _, highlight := range highlights {
-		content.WriteString(highlightPrefix)
-		content.WriteString(highlight.Text)
-		content.WriteString(fmt.Sprintf(pageFormat, highlight.Page))
-		content.WriteString("\n")
+func (s *Service) writeFile(filename, content string) error {
+	if err := s.backupFile(filename); err != nil {
+		return err
 	}
+	return s.fs.WriteFile(filename, []byte(content), filePermissions)
 }
 
-func (s *Service) writeFile(filename, content string) error {
-	return s.fs.WriteFile(filename, []byte(content), filePermissions)
+// removeFile deletes filename, backing it up first so Restore can bring it
+// back.
+func (s *Service) removeFile(filename string) error {
+	if err := s.backupFile(filename); err != nil {
+		return err
+	}
+	return s.fs.Remove(filename)
 }
 
 func (s *Service) extractExistingHighlights(content string) map[string]bool {
@@ -1,20 +1,38 @@
 package exporter
 
 import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/matthewrobinsdev/kindle-notes-parser/internal/config"
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/schema"
 	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
 )
 
 type MockFileSystem struct {
-	files map[string][]byte
-	dirs  map[string]bool
+	mu          sync.Mutex
+	files       map[string][]byte
+	dirs        map[string]bool
+	writeErrors map[string]error // Writes to these files fail
+	latency     time.Duration    // Each read and write takes this long, like a disk
 }
 
 func NewMockFileSystem() *MockFileSystem {
@@ -25,6 +43,8 @@ func NewMockFileSystem() *MockFileSystem {
 }
 
 func (fs *MockFileSystem) Stat(name string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	if _, exists := fs.files[name]; exists {
 		return nil, nil // File exists
 	}
@@ -32,6 +52,9 @@ func (fs *MockFileSystem) Stat(name string) (os.FileInfo, error) {
 }
 
 func (fs *MockFileSystem) ReadFile(filename string) ([]byte, error) {
+	time.Sleep(fs.latency)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	if data, exists := fs.files[filename]; exists {
 		return data, nil
 	}
@@ -39,15 +62,60 @@ func (fs *MockFileSystem) ReadFile(filename string) ([]byte, error) {
 }
 
 func (fs *MockFileSystem) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	time.Sleep(fs.latency)
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if err := fs.writeErrors[filename]; err != nil {
+		return err
+	}
 	fs.files[filename] = data
 	return nil
 }
 
 func (fs *MockFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 	fs.dirs[path] = true
 	return nil
 }
 
+func (fs *MockFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	var entries []os.DirEntry
+	for path, data := range fs.files {
+		if filepath.Dir(path) == name {
+			entries = append(entries, iofs.FileInfoToDirEntry(pendingFileInfo{name: filepath.Base(path), size: int64(len(data))}))
+		}
+	}
+	if len(entries) == 0 && !fs.dirs[name] {
+		return nil, os.ErrNotExist
+	}
+	slices.SortFunc(entries, func(a, b os.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, nil
+}
+
+func (fs *MockFileSystem) Remove(name string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, exists := fs.files[name]; !exists {
+		return os.ErrNotExist
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+func (fs *MockFileSystem) RemoveAll(path string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for name := range fs.files {
+		if name == path || strings.HasPrefix(name, path+"/") {
+			delete(fs.files, name)
+		}
+	}
+	return nil
+}
+
 func TestExtractExistingHighlights(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -225,6 +293,11 @@ func TestSanitizeFilename(t *testing.T) {
 			input:    strings.Repeat("A", 250),
 			expected: strings.Repeat("A", 200),
 		},
+		{
+			name:     "long filename cut between runes",
+			input:    "A" + strings.Repeat("é", 150),
+			expected: "A" + strings.Repeat("é", 99),
+		},
 	}
 
 	for _, tt := range tests {
@@ -273,3 +346,1602 @@ func TestExportHighlightsWithMockFS(t *testing.T) {
 	assert.Contains(t, content, "- First highlight (Page: 1)")
 	assert.Contains(t, content, "- Second highlight (Page: 2)")
 }
+
+func TestExportHighlightsReadwiseFormat(t *testing.T) {
+	cfg := &config.Config{
+		HomeDir:        "/home/user",
+		NotesDirectory: "notes",
+		ExportFormat:   string(FormatReadwise),
+	}
+
+	mockFS := NewMockFileSystem()
+	service := NewWithFileSystem(cfg, mockFS)
+
+	highlights := map[string][]models.Highlight{
+		"Sandworm": {
+			{Title: "Sandworm", Author: "Greenberg, Andy", Text: "Cascading failures", Location: "4933-4934", Date: "Monday, 6 May 2024 19:53:44"},
+		},
+		"Modern Software Engineering": {
+			{Title: "Modern Software Engineering", Author: "Farley, David", Text: "Communication overhead", Note: "Brooks", Location: "784-785"},
+		},
+	}
+
+	results, err := service.ExportHighlights(highlights)
+	require.NoError(t, err, "Should export without error")
+	require.Len(t, results, 2, "Should have one result per book")
+	assert.Equal(t, "Modern Software Engineering", results[0].BookTitle, "Results should be sorted by title")
+
+	data, exists := mockFS.files["/home/user/notes/kindle-highlights.csv"]
+	require.True(t, exists, "CSV file should be created")
+
+	expected := "Highlight,Title,Author,URL,Note,Location,Date\n" +
+		"Communication overhead,Modern Software Engineering,\"Farley, David\",,Brooks,784,\n" +
+		"Cascading failures,Sandworm,\"Greenberg, Andy\",,,4933,2024-05-06 19:53:44\n"
+	assert.Equal(t, expected, string(data))
+}
+
+func TestExportHighlightsUnknownFormat(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/test", NotesDirectory: "notes", ExportFormat: "docx"}
+	service := NewWithFileSystem(cfg, NewMockFileSystem())
+
+	_, err := service.ExportHighlights(map[string][]models.Highlight{
+		"Book": {{Text: "Text", Page: "1"}},
+	})
+	assert.Error(t, err, "Should reject unknown formats")
+}
+
+func TestExportHighlightsToStdout(t *testing.T) {
+	highlights := map[string][]models.Highlight{
+		"Book": {{Title: "Book", Author: "Author", Text: "Streamed", Location: "10"}},
+	}
+
+	t.Run("single-file format streams", func(t *testing.T) {
+		cfg := &config.Config{HomeDir: "/test", NotesDirectory: "notes", ExportFormat: string(FormatReadwise), ExportFile: StdoutPath}
+		mockFS := NewMockFileSystem()
+		service := NewWithFileSystem(cfg, mockFS)
+		stdout := &bytes.Buffer{}
+		service.SetStdout(stdout)
+
+		results, err := service.ExportHighlights(highlights)
+		require.NoError(t, err, "Should export without error")
+		require.Len(t, results, 1)
+
+		assert.Contains(t, stdout.String(), "Streamed,Book,Author,,,10,")
+		assert.Empty(t, mockFS.files, "Should not write any files")
+	})
+
+	t.Run("per-book format refuses", func(t *testing.T) {
+		cfg := &config.Config{HomeDir: "/test", NotesDirectory: "notes", ExportFile: StdoutPath}
+		service := NewWithFileSystem(cfg, NewMockFileSystem())
+
+		_, err := service.ExportHighlights(highlights)
+		assert.Error(t, err, "Markdown cannot be streamed to stdout")
+	})
+}
+
+func TestExportHighlightsWithTemplates(t *testing.T) {
+	cfg := &config.Config{
+		HomeDir:        "/home/user",
+		NotesDirectory: "notes",
+		Templates: config.Templates{
+			Header:    "/templates/header.tmpl",
+			Highlight: "/templates/highlight.tmpl",
+			Footer:    "/templates/footer.tmpl",
+		},
+	}
+
+	mockFS := NewMockFileSystem()
+	mockFS.files["/templates/header.tmpl"] = []byte("---\ntitle: {{.Title}}\nauthor: {{.Author}}\n---\n\n")
+	mockFS.files["/templates/highlight.tmpl"] = []byte("> {{wrap 20 .Text}}\n> — {{.Book.Author}}, {{date \"2006-01-02\" .Date}} [[{{slug .Book.Title}}]]\n\n")
+	mockFS.files["/templates/footer.tmpl"] = []byte("Exported {{len .Highlights}} highlights")
+
+	first := map[string][]models.Highlight{
+		"Test Book": {
+			{Title: "Test Book", Author: "Author", Text: "A fairly long first highlight", Page: "1", Date: "Monday, 6 May 2024 19:53:44"},
+		},
+	}
+
+	_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(first)
+	require.NoError(t, err, "Should export without error")
+
+	expected := "---\ntitle: Test Book\nauthor: Author\n---\n\n" +
+		"> A fairly long first\nhighlight\n> — Author, 2024-05-06 [[test-book]]\n\n" +
+		footerMarker + "Exported 1 highlights\n"
+	assert.Equal(t, expected, string(mockFS.files["/home/user/notes/Test Book.md"]))
+
+	second := map[string][]models.Highlight{
+		"Test Book": {
+			first["Test Book"][0],
+			{Title: "Test Book", Author: "Author", Text: "Second", Page: "2", Date: "Tuesday, 7 May 2024 08:00:00"},
+		},
+	}
+
+	results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(second)
+	require.NoError(t, err, "Should export without error")
+	require.Len(t, results, 1)
+	assert.Equal(t, 1, results[0].NewCount, "Should only add the new highlight")
+	assert.Equal(t, 1, results[0].SkippedCount, "Should recognise the templated highlight")
+
+	expected = "---\ntitle: Test Book\nauthor: Author\n---\n\n" +
+		"> A fairly long first\nhighlight\n> — Author, 2024-05-06 [[test-book]]\n\n" +
+		"> Second\n> — Author, 2024-05-07 [[test-book]]\n\n" +
+		footerMarker + "Exported 2 highlights\n"
+	assert.Equal(t, expected, string(mockFS.files["/home/user/notes/Test Book.md"]), "Footer should move below the new highlight")
+}
+
+func TestExportHighlightsWithInvalidTemplate(t *testing.T) {
+	cfg := &config.Config{
+		HomeDir:        "/home/user",
+		NotesDirectory: "notes",
+		Templates:      config.Templates{Highlight: "/templates/highlight.tmpl"},
+	}
+
+	mockFS := NewMockFileSystem()
+	mockFS.files["/templates/highlight.tmpl"] = []byte("- {{.Text")
+
+	_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{
+		"Book": {{Text: "Text", Page: "1"}},
+	})
+	assert.Error(t, err, "Should report template syntax errors")
+}
+
+func TestTemplateHelpers(t *testing.T) {
+	assert.Equal(t, "modern-software-engineering", slugify("Modern Software Engineering"))
+	assert.Equal(t, "a-b-c", slugify("  A/B: (C)  "))
+	assert.Equal(t, "one two\nthree", wrapText(7, "one two three"))
+	assert.Equal(t, "6 May 2024", formatDate("2 Jan 2006", "Monday, 6 May 2024 19:53:44"))
+	assert.Equal(t, "not a date", formatDate("2006", "not a date"))
+}
+
+func TestExportHighlightsWithFrontMatter(t *testing.T) {
+	cfg := &config.Config{
+		HomeDir:        "/home/user",
+		NotesDirectory: "notes",
+		FrontMatter:    config.FrontMatter{Enabled: true, Source: "kindle", Tags: []string{"books"}},
+	}
+	path := "/home/user/notes/Sandworm.md"
+	mockFS := NewMockFileSystem()
+
+	first := models.Highlight{Title: "Sandworm", Author: "Greenberg, Andy", Text: "First", Page: "1", Date: "Monday, 6 May 2024 19:53:44"}
+	_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {first}})
+	require.NoError(t, err, "Should export without error")
+
+	expected := "---\n" +
+		"title: Sandworm\n" +
+		"authors:\n  - Greenberg, Andy\n" +
+		"highlights: 1\n" +
+		"first_highlighted: \"2024-05-06\"\n" +
+		"last_highlighted: \"2024-05-06\"\n" +
+		"source: kindle\n" +
+		"tags:\n  - books\n" +
+		"---\n" +
+		"# Sandworm\n\n- First (Page: 1)\n"
+	assert.Equal(t, expected, string(mockFS.files[path]))
+
+	// The user edits the tags and adds their own key
+	edited := strings.Replace(string(mockFS.files[path]), "tags:\n  - books\n", "tags:\n  - security\nrating: 5 # out of 5\n", 1)
+	mockFS.files[path] = []byte(edited)
+
+	earlier := models.Highlight{Title: "Sandworm", Author: "Greenberg, Andy", Text: "Earlier", Page: "0", Date: "Wednesday, 10 April 2024 22:27:53"}
+	results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {first, earlier}})
+	require.NoError(t, err, "Should export without error")
+	assert.Equal(t, 1, results[0].NewCount)
+
+	expected = "---\n" +
+		"title: Sandworm\n" +
+		"authors:\n  - Greenberg, Andy\n" +
+		"highlights: 2\n" +
+		"first_highlighted: \"2024-04-10\"\n" +
+		"last_highlighted: \"2024-05-06\"\n" +
+		"source: kindle\n" +
+		"tags:\n  - security\n" +
+		"rating: 5 # out of 5\n" +
+		"---\n" +
+		"# Sandworm\n\n- First (Page: 1)\n- Earlier (Page: 0)\n"
+	assert.Equal(t, expected, string(mockFS.files[path]), "Should update counts and dates and keep user keys")
+}
+
+func TestSplitFrontMatter(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		expectedBlock string
+		expectedBody  string
+	}{
+		{name: "no front matter", content: "# Title\n", expectedBlock: "", expectedBody: "# Title\n"},
+		{name: "front matter", content: "---\na: 1\n---\n# Title\n", expectedBlock: "a: 1\n", expectedBody: "# Title\n"},
+		{name: "empty front matter", content: "---\n---\n# Title\n", expectedBlock: "", expectedBody: "# Title\n"},
+		{name: "unterminated", content: "---\na: 1\n", expectedBlock: "", expectedBody: "---\na: 1\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			block, body := splitFrontMatter(tt.content)
+			assert.Equal(t, tt.expectedBlock, block)
+			assert.Equal(t, tt.expectedBody, body)
+		})
+	}
+}
+
+func TestExportHighlightsObsidianFormat(t *testing.T) {
+	sandworm := []models.Highlight{
+		{Title: "Sandworm", Author: "Greenberg, Andy", Text: "Cascading failures", Page: "305", Location: "4933-4934"},
+		{Title: "Sandworm", Author: "Greenberg, Andy", Text: "Test", Page: "305", Location: "4933-4934", Note: "Same location"},
+	}
+	firstID, secondID := blockID(sandworm[0]), blockID(sandworm[1])
+	require.NotEqual(t, firstID, secondID, "Highlights sharing a location should get distinct block IDs")
+	assert.True(t, strings.HasPrefix(firstID, "loc-4933-"), "Block ID should be derived from the location")
+
+	t.Run("list layout with index note", func(t *testing.T) {
+		cfg := &config.Config{
+			HomeDir:        "/home/user",
+			NotesDirectory: "notes",
+			ExportFormat:   string(FormatObsidian),
+			Obsidian:       config.Obsidian{Index: "Books"},
+		}
+		mockFS := NewMockFileSystem()
+
+		_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": sandworm})
+		require.NoError(t, err, "Should export without error")
+
+		expected := "# Sandworm\n\nAuthor: [[Greenberg, Andy]]\n\n" +
+			"- Cascading failures (Page: 305) ^" + firstID + "\n" +
+			"- Test (Page: 305) ^" + secondID + "\n" +
+			"  - Note: Same location\n"
+		assert.Equal(t, expected, string(mockFS.files["/home/user/notes/Sandworm.md"]))
+		assert.Equal(t, "# Books\n\n- [[Sandworm]] by [[Greenberg, Andy]]\n", string(mockFS.files["/home/user/notes/Books.md"]))
+
+		// Reformatting an exported line keeps it recognised by its block ID
+		path := "/home/user/notes/Sandworm.md"
+		mockFS.files[path] = []byte(strings.Replace(string(mockFS.files[path]), "- Cascading failures (Page: 305)", "- **Cascading failures**", 1))
+
+		other := models.Highlight{Title: "A/B", Author: "Writer; Co-Writer", Text: "Other", Page: "1", Location: "10"}
+		results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{
+			"Sandworm": sandworm,
+			"A/B":      {other},
+		})
+		require.NoError(t, err, "Should export without error")
+		assert.Equal(t, 2, results[1].SkippedCount, "Should skip highlights by block ID")
+		assert.Equal(t, "# Books\n\n- [[Sandworm]] by [[Greenberg, Andy]]\n- [[A-B|A/B]] by [[Writer]], [[Co-Writer]]\n",
+			string(mockFS.files["/home/user/notes/Books.md"]), "Should add new books to the index once")
+	})
+
+	t.Run("index note after failures", func(t *testing.T) {
+		cfg := &config.Config{
+			HomeDir:        "/home/user",
+			NotesDirectory: "notes",
+			ExportFormat:   string(FormatObsidian),
+			OnError:        OnErrorContinue,
+			Obsidian:       config.Obsidian{Index: "Books"},
+		}
+		mockFS := NewMockFileSystem()
+		mockFS.writeErrors = map[string]error{"/home/user/notes/Sandworm.md": errors.New("disk full")}
+
+		_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{
+			"Sandworm":  sandworm,
+			"Anonymous": {{Title: "Anonymous", Text: "Unsigned", Page: "1", Location: "1"}},
+		})
+		require.Error(t, err)
+		assert.Equal(t, "# Books\n\n- [[Anonymous]]\n", string(mockFS.files["/home/user/notes/Books.md"]),
+			"Should link only the notes written, without a dangling author")
+	})
+
+	t.Run("callout layout", func(t *testing.T) {
+		cfg := &config.Config{
+			HomeDir:        "/home/user",
+			NotesDirectory: "notes",
+			ExportFormat:   string(FormatObsidian),
+			Obsidian:       config.Obsidian{Callouts: true},
+		}
+		mockFS := NewMockFileSystem()
+
+		_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": sandworm[1:]})
+		require.NoError(t, err, "Should export without error")
+
+		expected := "# Sandworm\n\nAuthor: [[Greenberg, Andy]]\n\n" +
+			"> [!quote] Page 305, location 4933-4934\n> Test\n>\n> > [!note]\n> > Same location\n\n^" + secondID + "\n\n"
+		assert.Equal(t, expected, string(mockFS.files["/home/user/notes/Sandworm.md"]))
+
+		results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": sandworm[1:]})
+		require.NoError(t, err, "Should export without error")
+		assert.Equal(t, 0, results[0].NewCount, "Should not duplicate callouts")
+	})
+}
+
+func TestExportHighlightsLogseqFormat(t *testing.T) {
+	cfg := &config.Config{
+		HomeDir:        "/home/user",
+		NotesDirectory: "graph",
+		ExportFormat:   string(FormatLogseq),
+		FrontMatter:    config.FrontMatter{Source: "kindle"},
+	}
+	mockFS := NewMockFileSystem()
+
+	highlight := models.Highlight{
+		Title: "Sandworm", Author: "Greenberg, Andy", Text: "Cascading failures",
+		Page: "305", Location: "4933-4934", Date: "Monday, 6 May 2024 19:53:44", Note: "See chapter 3",
+	}
+
+	_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {highlight}})
+	require.NoError(t, err, "Should export without error")
+
+	expected := "title:: Sandworm\nauthor:: [[Greenberg, Andy]]\nsource:: kindle\ntype:: book\n\n" +
+		"- Cascading failures\n" +
+		"  location:: 4933-4934\n" +
+		"  page:: 305\n" +
+		"  added:: [[May 6th, 2024]]\n" +
+		"  highlight-id:: " + highlight.ID() + "\n" +
+		"  - See chapter 3\n"
+	assert.Equal(t, expected, string(mockFS.files["/home/user/graph/pages/Sandworm.md"]), "Should write a page under pages/")
+
+	results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {highlight}})
+	require.NoError(t, err, "Should export without error")
+	assert.Equal(t, 1, results[0].SkippedCount, "Should skip blocks by highlight-id")
+}
+
+func TestWriteRoamJSON(t *testing.T) {
+	var output bytes.Buffer
+	err := WriteRoamJSON(&output, []models.Highlight{
+		{Title: "Sandworm", Author: "Greenberg, Andy", Text: "First", Location: "4933-4934", Date: "Monday, 6 May 2024 19:53:44"},
+		{Title: "Modern Software Engineering", Author: "Farley, David", Text: "Second", Page: "26"},
+		{Title: "Sandworm", Author: "Greenberg, Andy", Text: "Third", Note: "A note"},
+	})
+	require.NoError(t, err, "Should write without error")
+
+	expected := `[
+  {
+    "title": "Sandworm",
+    "children": [
+      {
+        "string": "Author:: [[Greenberg, Andy]]"
+      },
+      {
+        "string": "First",
+        "children": [
+          {
+            "string": "location:: 4933-4934"
+          },
+          {
+            "string": "added:: [[May 6th, 2024]]"
+          }
+        ]
+      },
+      {
+        "string": "Third",
+        "children": [
+          {
+            "string": "A note"
+          }
+        ]
+      }
+    ]
+  },
+  {
+    "title": "Modern Software Engineering",
+    "children": [
+      {
+        "string": "Author:: [[Farley, David]]"
+      },
+      {
+        "string": "Second",
+        "children": [
+          {
+            "string": "page:: 26"
+          }
+        ]
+      }
+    ]
+  }
+]
+`
+	assert.Equal(t, expected, output.String())
+}
+
+func TestJournalDate(t *testing.T) {
+	tests := map[int]string{1: "Jan 1st, 2024", 2: "Jan 2nd, 2024", 3: "Jan 3rd, 2024", 11: "Jan 11th, 2024", 22: "Jan 22nd, 2024", 30: "Jan 30th, 2024"}
+
+	for day, expected := range tests {
+		assert.Equal(t, expected, journalDate(time.Date(2024, time.January, day, 0, 0, 0, 0, time.UTC)))
+	}
+
+	// Logseq abbreviates the month but Roam spells it out
+	date := time.Date(2024, time.September, 6, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "Sep 6th, 2024", journalDate(date), "Logseq should abbreviate the month")
+	assert.Equal(t, "September 6th, 2024", roamDate(date), "Roam should spell the month out")
+}
+
+func TestExportHighlightsOrgFormat(t *testing.T) {
+	// Front matter is YAML for markdown, so org notes never get it
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "org", ExportFormat: string(FormatOrg), FrontMatter: config.FrontMatter{Enabled: true, Source: "kindle"}}
+	mockFS := NewMockFileSystem()
+
+	highlight := models.Highlight{
+		Title: "Sandworm", Author: "Greenberg, Andy", Page: "305", Location: "4933-4934", Date: "Monday, 6 May 2024 19:53:44",
+		Text: "Put more simply, a complex system like a digitized civilization is subject to cascading failures.",
+	}
+
+	_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {highlight}})
+	require.NoError(t, err, "Should export without error")
+
+	expected := "#+TITLE: Sandworm\n#+AUTHOR: Greenberg, Andy\n\n" +
+		"* Put more simply, a complex system like a digitized civili...\n" +
+		":PROPERTIES:\n" +
+		":ID:       " + highlight.ID() + "\n" +
+		":PAGE:     305\n" +
+		":LOCATION: 4933-4934\n" +
+		":ADDED:    [2024-05-06 Mon 19:53]\n" +
+		":END:\n" +
+		"#+BEGIN_QUOTE\n" + highlight.Text + "\n#+END_QUOTE\n\n"
+	path := "/home/user/org/Sandworm.org"
+	assert.Equal(t, expected, string(mockFS.files[path]))
+
+	// Editing the headline and quote keeps the highlight recognised by its ID
+	mockFS.files[path] = []byte(strings.Replace(string(mockFS.files[path]), "* Put more simply", "* TODO Put more simply", 1))
+
+	results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {highlight}})
+	require.NoError(t, err, "Should export without error")
+	assert.Equal(t, 1, results[0].SkippedCount, "Should skip highlights by ID property")
+}
+
+func TestExportHighlightsLibraryFormats(t *testing.T) {
+	highlights := map[string][]models.Highlight{
+		"Sandworm": {
+			{Title: "Sandworm", Author: "Greenberg, Andy", Text: "First, with a comma", Page: "305", Location: "4933-4934", Date: "Monday, 6 May 2024 19:53:44", Note: "Note"},
+			{Title: "Sandworm", Author: "Greenberg, Andy", Text: "Second", Location: "bad"},
+		},
+	}
+	first := highlights["Sandworm"][0]
+
+	t.Run("json", func(t *testing.T) {
+		cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", ExportFormat: string(FormatJSON)}
+		mockFS := NewMockFileSystem()
+
+		results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(highlights)
+		require.NoError(t, err, "Should export without error")
+		require.Len(t, results, 1)
+
+		var document struct {
+			SchemaVersion int           `json:"schema_version"`
+			Kind          string        `json:"kind"`
+			Data          []schema.Book `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(mockFS.files["/home/user/notes/kindle-highlights.json"], &document))
+
+		assert.Equal(t, schema.Version, document.SchemaVersion)
+		assert.Equal(t, "library", document.Kind)
+		require.Len(t, document.Data, 1)
+		assert.Equal(t, 2, document.Data[0].HighlightCount)
+		assert.Equal(t, first.ID(), document.Data[0].Highlights[0].ID)
+		assert.Equal(t, document.Data[0].ID, document.Data[0].Highlights[0].BookID)
+		assert.Equal(t, "Note", document.Data[0].Highlights[0].Note)
+		assert.Nil(t, document.Data[0].Highlights[1].Location, "Unparseable locations should be omitted")
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", ExportFormat: string(FormatCSV)}
+		mockFS := NewMockFileSystem()
+
+		_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(highlights)
+		require.NoError(t, err, "Should export without error")
+
+		bookID := models.BookGroup{Title: "Sandworm", Author: "Greenberg, Andy"}.ID()
+		expected := "id,book_id,title,author,text,note,page,location,location_start,location_end,date,added_at\n" +
+			first.ID() + "," + bookID + ",Sandworm,\"Greenberg, Andy\",\"First, with a comma\",Note,305,4933-4934,4933,4934,\"Monday, 6 May 2024 19:53:44\",2024-05-06T19:53:44Z\n" +
+			highlights["Sandworm"][1].ID() + "," + bookID + ",Sandworm,\"Greenberg, Andy\",Second,,,bad,,,,\n"
+		assert.Equal(t, expected, string(mockFS.files["/home/user/notes/kindle-highlights.csv"]))
+	})
+}
+
+func TestExportHighlightsHTMLSite(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", ExportFormat: string(FormatHTML), ExportFile: "/srv/highlights"}
+	mockFS := NewMockFileSystem()
+
+	highlights := map[string][]models.Highlight{
+		"Sandworm": {
+			{Title: "Sandworm", Author: "Greenberg, Andy", Text: "Systems <fail>", Page: "305", Location: "4933-4934", Note: "Grid"},
+		},
+		"Sandworm!": {
+			{Title: "Sandworm!", Author: "Greenberg, Andy", Text: "Same slug", Location: "1"},
+		},
+		"Modern Software Engineering": {
+			{Title: "Modern Software Engineering", Author: "Farley, David", Text: "Overhead", Location: "784-785"},
+		},
+	}
+
+	results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(highlights)
+	require.NoError(t, err, "Should export without error")
+	require.Len(t, results, 3)
+
+	index := string(mockFS.files["/srv/highlights/index.html"])
+	assert.Contains(t, index, `<a href="books/sandworm.html">Sandworm</a>`)
+	assert.Contains(t, index, `<a href="books/modern-software-engineering.html">Modern Software Engineering</a>`)
+	assert.NotContains(t, index, "<link", "Should not reference external assets")
+
+	collidingID := models.BookGroup{Title: "Sandworm!", Author: "Greenberg, Andy"}.ID()
+	assert.Contains(t, index, `<a href="books/`+collidingID+`.html">Sandworm!</a>`, "Colliding slugs should fall back to the book ID")
+
+	book := string(mockFS.files["/srv/highlights/books/sandworm.html"])
+	assert.Contains(t, book, `<blockquote id="h-`+highlights["Sandworm"][0].ID()+`">`)
+	assert.Contains(t, book, "Systems &lt;fail&gt;", "Highlight text should be escaped")
+	assert.Contains(t, book, `<p class="note">Grid</p>`)
+	assert.Contains(t, book, `href="../index.html"`)
+
+	authors := string(mockFS.files["/srv/highlights/authors.html"])
+	assert.Less(t, strings.Index(authors, "Farley, David"), strings.Index(authors, "Greenberg, Andy"), "Authors should be sorted")
+
+	// The search index is inlined, so the site works from file://
+	_, inlined, found := strings.Cut(index, "var index = ")
+	require.True(t, found, "Should inline the search index")
+	inlined, _, _ = strings.Cut(inlined, ";\n")
+	var entries []searchEntry
+	require.NoError(t, json.Unmarshal([]byte(inlined), &entries))
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "books/modern-software-engineering.html", entries[0].Path)
+	assert.Equal(t, "Systems <fail>", entries[1].Text)
+	assert.NotContains(t, mockFS.files, "/srv/highlights/search-index.json")
+}
+
+func TestExportHighlightsHTMLSiteRemovesStalePages(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", ExportFormat: string(FormatHTML), ExportFile: "/srv/highlights", Backups: 10}
+	mockFS := NewMockFileSystem()
+	mockFS.files["/srv/highlights/search-index.json"] = []byte("[]")
+	mockFS.files["/srv/highlights/books/notes.txt"] = []byte("Mine")
+
+	sandworm := models.Highlight{Title: "Sandworm", Author: "Greenberg, Andy", Text: "Systems", Location: "1"}
+	dune := models.Highlight{Title: "Dune", Author: "Herbert, Frank", Text: "Fear", Location: "2"}
+	_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {sandworm}, "Dune": {dune}})
+	require.NoError(t, err, "Should export without error")
+	require.Contains(t, mockFS.files, "/srv/highlights/books/dune.html")
+	assert.NotContains(t, mockFS.files, "/srv/highlights/search-index.json", "Should remove the search index of older versions")
+
+	// Dune is no longer exported; a dry run shows its page going away
+	service := NewWithFileSystem(cfg, mockFS)
+	preview, err := service.DryRun(map[string][]models.Highlight{"Sandworm": {sandworm}})
+	require.NoError(t, err, "Should preview without error")
+	require.Contains(t, mockFS.files, "/srv/highlights/books/dune.html", "A dry run should not remove anything")
+	_, _, deleted := preview.Counts()
+	assert.Equal(t, 1, deleted)
+
+	_, err = service.ExportHighlights(map[string][]models.Highlight{"Sandworm": {sandworm}})
+	require.NoError(t, err, "Should export without error")
+	assert.NotContains(t, mockFS.files, "/srv/highlights/books/dune.html", "Should remove the page of a book no longer exported")
+	assert.Contains(t, mockFS.files, "/srv/highlights/books/sandworm.html")
+	assert.Contains(t, mockFS.files, "/srv/highlights/books/notes.txt", "Should leave other files alone")
+
+	_, err = service.Restore()
+	require.NoError(t, err, "Should restore without error")
+	assert.Contains(t, mockFS.files, "/srv/highlights/books/dune.html", "Should bring the page back on restore")
+}
+
+func TestWriteEPUB(t *testing.T) {
+	highlights := []models.Highlight{
+		{Title: "Sandworm", Author: "Greenberg, Andy", Text: "Systems & <failures>", Page: "305", Location: "4933-4934", Date: "Monday, 6 May 2024 19:53:44", Note: "Grid"},
+		{Title: "Modern Software Engineering", Author: "Farley, David", Text: "Overhead", Location: "784-785", Date: "Sunday, 12 May 2024 09:50:49"},
+	}
+
+	var output bytes.Buffer
+	require.NoError(t, WriteEPUB(&output, highlights), "Should write without error")
+
+	archive, err := zip.NewReader(bytes.NewReader(output.Bytes()), int64(output.Len()))
+	require.NoError(t, err, "Should be a valid zip archive")
+
+	require.NotEmpty(t, archive.File)
+	assert.Equal(t, "mimetype", archive.File[0].Name, "mimetype should be the first entry")
+	assert.Equal(t, zip.Store, archive.File[0].Method, "mimetype should be stored uncompressed")
+
+	files := make(map[string]string)
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		files[file.Name] = string(data)
+
+		if strings.HasSuffix(file.Name, ".xml") || strings.HasSuffix(file.Name, ".opf") || strings.HasSuffix(file.Name, ".xhtml") {
+			requireWellFormedXML(t, file.Name, data)
+		}
+	}
+
+	assert.Equal(t, "application/epub+zip", files["mimetype"])
+	assert.Contains(t, files["META-INF/container.xml"], `full-path="OEBPS/content.opf"`)
+	assert.Contains(t, files["OEBPS/content.opf"], `<meta property="dcterms:modified">2024-05-12T09:50:49Z</meta>`)
+	assert.Contains(t, files["OEBPS/content.opf"], `<itemref idref="chapter-1"/>`)
+	assert.Contains(t, files["OEBPS/nav.xhtml"], `<li><a href="chapter-001.xhtml">Sandworm</a></li>`)
+	assert.Contains(t, files["OEBPS/chapter-001.xhtml"], "<p>Systems &amp; &lt;failures&gt;</p>", "Text should be escaped")
+	assert.Contains(t, files["OEBPS/chapter-001.xhtml"], `<p class="note">Grid</p>`)
+	assert.Contains(t, files["OEBPS/chapter-002.xhtml"], "<h1>Modern Software Engineering</h1>")
+
+	var again bytes.Buffer
+	require.NoError(t, WriteEPUB(&again, highlights))
+	assert.Equal(t, output.Bytes(), again.Bytes(), "The same selection should produce the same file")
+}
+
+// requireWellFormedXML decodes the whole document, which must open with an
+// XML declaration and hold nothing but whitespace outside its one root
+// element. The decoder alone accepts stray text there.
+func requireWellFormedXML(t *testing.T, name string, data []byte) {
+	t.Helper()
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	depth, roots := 0, 0
+	for i := 0; ; i++ {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err, "%s should be well-formed XML", name)
+
+		if i == 0 {
+			declaration, ok := token.(xml.ProcInst)
+			require.True(t, ok && declaration.Target == "xml", "%s should open with an XML declaration", name)
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				roots++
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			if depth == 0 {
+				require.Empty(t, strings.TrimSpace(string(token)), "%s should have no text outside the root element", name)
+			}
+		}
+	}
+	require.Equal(t, 1, roots, "%s should have one root element", name)
+}
+
+func TestWriteAnkiText(t *testing.T) {
+	highlights := []models.Highlight{
+		{Title: "Sandworm", Author: "Greenberg, Andy", Text: "Systems & failures", Page: "305", Location: "4933-4934", Note: "Grid"},
+		{Title: "Modern Software Engineering", Author: "Farley, David", Text: "Overhead", Location: "784-785"},
+	}
+
+	tests := []struct {
+		name     string
+		opts     AnkiOptions
+		expected []string
+	}{
+		{
+			name: "basic cards",
+			opts: AnkiOptions{Deck: "Kindle Highlights"},
+			expected: []string{
+				"#separator:tab\n#html:true\n#notetype:Basic\n#deck:Kindle Highlights\n#guid column:1\n#tags column:4\n",
+				highlights[0].ID() + "\tSystems &amp; failures\tGrid<br><br><i>Sandworm</i> — Greenberg, Andy, page 305, location 4933-4934\tkindle sandworm\n",
+				highlights[1].ID() + "\tOverhead\t<i>Modern Software Engineering</i> — Farley, David, location 784-785\tkindle modern-software-engineering\n",
+			},
+		},
+		{
+			name: "cloze cards",
+			opts: AnkiOptions{Cloze: true},
+			expected: []string{
+				"#notetype:Cloze\n#guid column:1\n",
+				"\tSystems &amp; {{c1::failures}}\tGrid<br><br>",
+				"\t{{c1::Overhead}}\t",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			require.NoError(t, WriteAnkiText(&output, highlights, tt.opts))
+
+			for _, expected := range tt.expected {
+				assert.Contains(t, output.String(), expected)
+			}
+		})
+	}
+}
+
+func TestClozeText(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{text: "The grid went dark", expected: "The {{c1::grid}} went dark"},
+		{text: "Stuxnet's payload", expected: "{{c1::Stuxnet&#39;s}} payload"},
+		{text: "a <b> c", expected: "{{c1::a}} &lt;b&gt; c"},
+		{text: "...", expected: "{{c1::...}}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			assert.Equal(t, tt.expected, clozeText(tt.text))
+		})
+	}
+}
+
+func TestExportHighlightsAnkiPackage(t *testing.T) {
+	mockFS := NewMockFileSystem()
+	cfg := &config.Config{
+		NotesDirectory: "notes",
+		HomeDir:        "/home/test",
+		ExportFormat:   "apkg",
+		Anki:           config.Anki{Deck: "Kindle Highlights"},
+	}
+	service := NewWithFileSystem(cfg, mockFS)
+
+	highlights := map[string][]models.Highlight{
+		"Sandworm": {
+			{Title: "Sandworm", Author: "Greenberg, Andy", Text: "Systems fail", Location: "4933-4934", Date: "Monday, 6 May 2024 19:53:44", Note: "Grid"},
+			{Title: "Sandworm", Author: "Greenberg, Andy", Text: "Lights out", Location: "5001-5002"},
+		},
+	}
+
+	results, err := service.ExportHighlights(highlights)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, 2, results[0].NewCount)
+
+	data, ok := mockFS.files["/home/test/notes/kindle-highlights.apkg"]
+	require.True(t, ok, "Package should be written to the notes directory")
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err, "Should be a valid zip archive")
+
+	files := make(map[string][]byte)
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		files[file.Name] = content
+	}
+	assert.Equal(t, "{}", string(files["media"]))
+
+	collection := filepath.Join(t.TempDir(), "collection.anki2")
+	require.NoError(t, os.WriteFile(collection, files["collection.anki2"], 0644))
+
+	db, err := sql.Open("sqlite", collection)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var decks string
+	require.NoError(t, db.QueryRow("SELECT decks FROM col").Scan(&decks))
+	assert.Contains(t, decks, `"name":"Kindle Highlights"`)
+
+	var cards int
+	require.NoError(t, db.QueryRow("SELECT count(*) FROM cards").Scan(&cards))
+	assert.Equal(t, 2, cards)
+
+	var fields string
+	require.NoError(t, db.QueryRow("SELECT flds FROM notes WHERE guid = ?", highlights["Sandworm"][0].ID()).Scan(&fields))
+	assert.Equal(t, "Systems fail\x1fGrid<br><br><i>Sandworm</i> — Greenberg, Andy, location 4933-4934", fields)
+}
+
+func TestExportHighlightsZettelkastenFormat(t *testing.T) {
+	cfg := &config.Config{
+		HomeDir:        "/home/user",
+		NotesDirectory: "vault",
+		ExportFormat:   string(FormatZettel),
+		FrontMatter:    config.FrontMatter{Source: "kindle", Tags: []string{"quote"}},
+		Zettelkasten:   config.Zettelkasten{Directory: "zettel"},
+	}
+	mockFS := NewMockFileSystem()
+
+	first := models.Highlight{
+		Title: "Sandworm: A New Era", Author: "Greenberg, Andy", Text: "Cascading failures",
+		Page: "305", Location: "4933-4934", Date: "Monday, 6 May 2024 19:53:44", Note: "See chapter 3",
+	}
+	second := models.Highlight{Title: "Sandworm: A New Era", Author: "Greenberg, Andy", Text: "Lights out", Location: "5001-5002"}
+
+	results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm: A New Era": {first}})
+	require.NoError(t, err, "Should export without error")
+	assert.Equal(t, 1, results[0].NewCount)
+
+	zettel := "/home/user/vault/zettel/" + first.ID() + ".md"
+	expected := "---\n" +
+		"id: " + first.ID() + "\n" +
+		"book: '[[Sandworm- A New Era|Sandworm: A New Era]]'\n" +
+		"authors:\n  - Greenberg, Andy\n" +
+		"page: \"305\"\n" +
+		"location: 4933-4934\n" +
+		"added: \"2024-05-06\"\n" +
+		"source: kindle\n" +
+		"tags:\n  - quote\n" +
+		"---\n\n" +
+		"> Cascading failures\n\n" +
+		"See chapter 3\n"
+	assert.Equal(t, expected, string(mockFS.files[zettel]), "Should write one note per highlight named by its ID")
+
+	bookNote := "/home/user/vault/Sandworm- A New Era.md"
+	assert.Equal(t, "# Sandworm: A New Era\n\nAuthor: [[Greenberg, Andy]]\n\n- [["+first.ID()+"]] Cascading failures\n", string(mockFS.files[bookNote]))
+
+	// Edits to existing notes survive re-exports
+	mockFS.files[zettel] = []byte("edited")
+	mockFS.files[bookNote] = append(mockFS.files[bookNote], []byte("My thoughts\n")...)
+
+	results, err = NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm: A New Era": {first, second}})
+	require.NoError(t, err, "Should export without error")
+	assert.Equal(t, 1, results[0].NewCount)
+	assert.Equal(t, 1, results[0].SkippedCount, "Should skip highlights whose note exists")
+	assert.Equal(t, "edited", string(mockFS.files[zettel]), "Should never overwrite an existing note")
+	assert.Contains(t, string(mockFS.files["/home/user/vault/zettel/"+second.ID()+".md"]), "> Lights out\n")
+	assert.True(t, strings.HasSuffix(string(mockFS.files[bookNote]), "My thoughts\n- [["+second.ID()+"]] Lights out\n"), "Should append new links to the book note")
+}
+
+func TestExportHighlightsSyncState(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes"}
+	mockFS := NewMockFileSystem()
+
+	first := models.Highlight{Title: "Sandworm", Author: "Greenberg, Andy", Text: "Cascading failures", Page: "305", Location: "4933-4934"}
+	second := models.Highlight{Title: "Sandworm", Author: "Greenberg, Andy", Text: "Lights out", Page: "x", Location: "5001-5002"}
+	notePath := "/home/user/notes/Sandworm.md"
+	statePath := "/home/user/notes/.kindle-highlights/state.json"
+
+	_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {first}})
+	require.NoError(t, err, "Should export without error")
+
+	var state syncState
+	require.NoError(t, json.Unmarshal(mockFS.files[statePath], &state), "Should write the manifest")
+	assert.Equal(t, stateVersion, state.Version)
+	require.Contains(t, state.Files, "Sandworm.md", "Should key files by their path in the notes directory")
+	assert.Equal(t, []string{first.ID()}, state.Files["Sandworm.md"].Highlights)
+
+	// Reformatting a line no longer makes it look new
+	mockFS.files[notePath] = []byte("# Sandworm\n\n> Cascading failures — p. 305\n> My own comment\n")
+
+	results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {first, second}})
+	require.NoError(t, err, "Should export without error")
+	assert.Equal(t, 1, results[0].NewCount)
+	assert.Equal(t, 1, results[0].SkippedCount, "Should skip highlights recorded in the manifest")
+	assert.Equal(t, "# Sandworm\n\n> Cascading failures — p. 305\n> My own comment\n- Lights out (Page: x)\n", string(mockFS.files[notePath]))
+
+	results, err = NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {first, second}})
+	require.NoError(t, err, "Should export without error")
+	assert.Equal(t, 2, results[0].SkippedCount, "Should skip non-numeric pages once recorded")
+
+	// Deleting the note exports it again from scratch
+	delete(mockFS.files, notePath)
+	results, err = NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {first, second}})
+	require.NoError(t, err, "Should export without error")
+	assert.Equal(t, 2, results[0].NewCount)
+}
+
+func TestExportHighlightsAdoptsUntrackedFiles(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes"}
+	mockFS := NewMockFileSystem()
+	mockFS.files["/home/user/notes/Sandworm.md"] = []byte("# Sandworm\n\n- Cascading failures (Page: 305)\n")
+
+	highlight := models.Highlight{Title: "Sandworm", Text: "Cascading failures", Page: "305"}
+	results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {highlight}})
+	require.NoError(t, err, "Should export without error")
+	assert.Equal(t, 1, results[0].SkippedCount, "Should scan notes written before the manifest existed")
+
+	var state syncState
+	require.NoError(t, json.Unmarshal(mockFS.files["/home/user/notes/.kindle-highlights/state.json"], &state))
+	assert.Equal(t, []string{highlight.ID()}, state.Files["Sandworm.md"].Highlights, "Should record highlights found in untracked notes")
+}
+
+func TestExportHighlightsInvalidSyncState(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes"}
+	mockFS := NewMockFileSystem()
+	mockFS.files["/home/user/notes/.kindle-highlights/state.json"] = []byte("{not json")
+
+	_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{
+		"Book": {{Title: "Book", Text: "Text", Page: "1"}},
+	})
+	assert.Error(t, err, "Should refuse to export with an unreadable manifest")
+	assert.NotContains(t, mockFS.files, "/home/user/notes/Book.md")
+}
+
+func TestExportHighlightsOrderedMerge(t *testing.T) {
+	early := models.Highlight{Title: "Sandworm", Text: "Early", Page: "1", Location: "100-101"}
+	middle := models.Highlight{Title: "Sandworm", Text: "Middle", Page: "2", Location: "200-201"}
+	late := models.Highlight{Title: "Sandworm", Text: "Late", Page: "3", Location: "300-301"}
+	latest := models.Highlight{Title: "Sandworm", Text: "Latest", Page: "4", Location: "400-401"}
+
+	tests := []struct {
+		name     string
+		format   Format
+		existing string
+		expected string
+	}{
+		{
+			name:     "markdown keeps commentary with the highlight above it",
+			format:   FormatMarkdown,
+			existing: "# Sandworm\n\n- Early (Page: 1)\nMy thoughts on early\n\n- Late (Page: 3)\n",
+			expected: "# Sandworm\n\n- Early (Page: 1)\nMy thoughts on early\n\n- Middle (Page: 2)\n- Late (Page: 3)\n- Latest (Page: 4)\n",
+		},
+		{
+			name:   "obsidian callouts",
+			format: FormatObsidian,
+			existing: "# Sandworm\n\n" +
+				"> [!quote] Page 1, location 100-101\n> Early\n\n^" + blockID(early) + "\n\n" +
+				"> [!quote] Page 3, location 300-301\n> Late\n\n^" + blockID(late) + "\n\n",
+			expected: "# Sandworm\n\n" +
+				"> [!quote] Page 1, location 100-101\n> Early\n\n^" + blockID(early) + "\n\n" +
+				"> [!quote] Page 2, location 200-201\n> Middle\n\n^" + blockID(middle) + "\n\n" +
+				"> [!quote] Page 3, location 300-301\n> Late\n\n^" + blockID(late) + "\n\n" +
+				"> [!quote] Page 4, location 400-401\n> Latest\n\n^" + blockID(latest) + "\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				HomeDir:        "/home/user",
+				NotesDirectory: "notes",
+				ExportFormat:   string(tt.format),
+				Merge:          MergeOrdered,
+				Obsidian:       config.Obsidian{Callouts: true},
+			}
+			mockFS := NewMockFileSystem()
+			mockFS.files["/home/user/notes/Sandworm.md"] = []byte(tt.existing)
+
+			results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{
+				"Sandworm": {latest, early, late, middle},
+			})
+			require.NoError(t, err, "Should export without error")
+			assert.Equal(t, 2, results[0].NewCount)
+			assert.Equal(t, tt.expected, string(mockFS.files["/home/user/notes/Sandworm.md"]))
+		})
+	}
+}
+
+func TestExportHighlightsOrderedMergeSeparateRuns(t *testing.T) {
+	first := models.Highlight{Title: "Sandworm", Text: "First", Page: "1", Location: "100-101"}
+	second := models.Highlight{Title: "Sandworm", Text: "Second", Page: "2", Location: "200-201"}
+	third := models.Highlight{Title: "Sandworm", Text: "Third", Page: "3", Location: "300-301"}
+
+	tests := []struct {
+		name     string
+		format   Format
+		callouts bool
+		path     string
+	}{
+		{name: "markdown by page", format: FormatMarkdown, path: "/home/user/notes/Sandworm.md"},
+		{name: "obsidian block IDs", format: FormatObsidian, path: "/home/user/notes/Sandworm.md"},
+		{name: "obsidian callouts", format: FormatObsidian, callouts: true, path: "/home/user/notes/Sandworm.md"},
+		{name: "org location property", format: FormatOrg, path: "/home/user/notes/Sandworm.org"},
+		{name: "logseq location property", format: FormatLogseq, path: "/home/user/notes/pages/Sandworm.md"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				HomeDir:        "/home/user",
+				NotesDirectory: "notes",
+				ExportFormat:   string(tt.format),
+				Merge:          MergeOrdered,
+				Obsidian:       config.Obsidian{Callouts: tt.callouts},
+			}
+			mockFS := NewMockFileSystem()
+
+			_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {first, third}})
+			require.NoError(t, err, "Should export without error")
+
+			// The second highlight is exported on its own, placed by what
+			// the note already holds
+			_, err = NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {second}})
+			require.NoError(t, err, "Should export without error")
+
+			content := string(mockFS.files[tt.path])
+			require.Contains(t, content, "Second")
+			assert.Less(t, strings.Index(content, "First"), strings.Index(content, "Second"))
+			assert.Less(t, strings.Index(content, "Second"), strings.Index(content, "Third"))
+		})
+	}
+}
+
+func TestExportHighlightsOrderedMergeOrg(t *testing.T) {
+	first := models.Highlight{Title: "Sandworm", Text: "First", Location: "100"}
+	second := models.Highlight{Title: "Sandworm", Text: "Second", Location: "200"}
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", ExportFormat: string(FormatOrg), Merge: MergeOrdered}
+	mockFS := NewMockFileSystem()
+
+	_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {second}})
+	require.NoError(t, err, "Should export without error")
+
+	path := "/home/user/notes/Sandworm.org"
+	mockFS.files[path] = []byte(strings.Replace(string(mockFS.files[path]), "* Second", "* TODO Second :idea:", 1))
+
+	_, err = NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {first, second}})
+	require.NoError(t, err, "Should export without error")
+
+	content := string(mockFS.files[path])
+	assert.Less(t, strings.Index(content, "* First"), strings.Index(content, "* TODO Second :idea:"), "Should insert before the later headline")
+}
+
+func TestExportHighlightsUnknownMerge(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/test", NotesDirectory: "notes", Merge: "sideways"}
+	_, err := NewWithFileSystem(cfg, NewMockFileSystem()).ExportHighlights(map[string][]models.Highlight{
+		"Book": {{Text: "Text", Page: "1"}},
+	})
+	assert.Error(t, err, "Should reject unknown merge modes")
+}
+
+func TestExportHighlightsManagedRegions(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", ManagedRegions: true}
+	path := "/home/user/notes/Sandworm.md"
+
+	first := models.Highlight{Title: "Sandworm", Text: "Cascading failures", Page: "305", Location: "4933-4934"}
+	second := models.Highlight{Title: "Sandworm", Text: "Lights out", Page: "310", Location: "5001-5002"}
+
+	mockFS := NewMockFileSystem()
+	_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {first, second}})
+	require.NoError(t, err, "Should export without error")
+
+	block := "- Cascading failures (Page: 305)\n"
+	expectedFirst := "<!-- kindle-highlights:begin id=" + first.ID() + " loc=4933 sum=" + blockChecksum(block) + " -->\n" +
+		block +
+		"<!-- kindle-highlights:end id=" + first.ID() + " -->\n"
+	content := string(mockFS.files[path])
+	require.True(t, strings.HasPrefix(content, "# Sandworm\n\n"+expectedFirst), "Should wrap each highlight in markers")
+
+	// The user annotates between blocks and edits the second one, then
+	// both highlights change in the source
+	content = strings.Replace(content, expectedFirst, expectedFirst+"My commentary\n", 1)
+	content = strings.Replace(content, "- Lights out (Page: 310)\n", "- Lights out (Page: 310) — so true\n", 1)
+	mockFS.files[path] = []byte(content)
+
+	first.Page, second.Page = "306", "311"
+	// Page is not part of the ID, so these are the same highlights
+	results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {first, second}})
+	require.NoError(t, err, "Should export without error")
+	require.Len(t, results, 1)
+	assert.Equal(t, 0, results[0].NewCount)
+	assert.Equal(t, 1, results[0].UpdatedCount, "Should refresh the unedited block")
+	assert.Equal(t, []models.Conflict{{HighlightID: second.ID(), Reason: conflictEdited}}, results[0].Conflicts, "Should report the edited block")
+
+	content = string(mockFS.files[path])
+	assert.Contains(t, content, "- Cascading failures (Page: 306)\n<!-- kindle-highlights:end id="+first.ID()+" -->\nMy commentary\n", "Should keep text outside the markers")
+	assert.Contains(t, content, "- Lights out (Page: 310) — so true\n", "Should never overwrite an edited block")
+
+	// Deleting a block keeps the highlight out
+	start := strings.Index(content, "<!-- kindle-highlights:begin id="+second.ID())
+	end := strings.Index(content, "<!-- kindle-highlights:end id="+second.ID()+" -->\n") + len("<!-- kindle-highlights:end id="+second.ID()+" -->\n")
+	mockFS.files[path] = []byte(content[:start] + content[end:])
+
+	results, err = NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {first, second}})
+	require.NoError(t, err, "Should export without error")
+	assert.Equal(t, 0, results[0].NewCount)
+	assert.Empty(t, results[0].Conflicts)
+	assert.NotContains(t, string(mockFS.files[path]), "Lights out")
+}
+
+func TestExportHighlightsManagedRegionsOrdered(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", ExportFormat: string(FormatOrg), ManagedRegions: true, Merge: MergeOrdered}
+	path := "/home/user/notes/Sandworm.org"
+
+	early := models.Highlight{Title: "Sandworm", Text: "Early", Location: "100"}
+	late := models.Highlight{Title: "Sandworm", Text: "Late", Location: "300"}
+	middle := models.Highlight{Title: "Sandworm", Text: "Middle", Location: "200"}
+
+	mockFS := NewMockFileSystem()
+	_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {late, early}})
+	require.NoError(t, err, "Should export without error")
+	assert.Contains(t, string(mockFS.files[path]), "# kindle-highlights:begin id="+early.ID()+" loc=100 sum=", "Should use Org comments")
+
+	// Only the new highlight is exported, placed by the markers alone
+	_, err = NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {middle}})
+	require.NoError(t, err, "Should export without error")
+
+	content := string(mockFS.files[path])
+	assert.Less(t, strings.Index(content, "* Early"), strings.Index(content, "* Middle"))
+	assert.Less(t, strings.Index(content, "* Middle"), strings.Index(content, "* Late"))
+}
+
+func TestExportHighlightsManagedRegionsLogseq(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "graph", ExportFormat: string(FormatLogseq), ManagedRegions: true}
+	_, err := NewWithFileSystem(cfg, NewMockFileSystem()).ExportHighlights(map[string][]models.Highlight{
+		"Book": {{Title: "Book", Text: "Text"}},
+	})
+	assert.Error(t, err, "Should reject managed regions for outlines")
+}
+
+func TestDryRun(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes"}
+	mockFS := NewMockFileSystem()
+	mockFS.files["/home/user/notes/Sandworm.md"] = []byte("# Sandworm\n\n- Cascading failures (Page: 305)\n")
+
+	preview, err := NewWithFileSystem(cfg, mockFS).DryRun(map[string][]models.Highlight{
+		"Sandworm": {
+			{Title: "Sandworm", Text: "Cascading failures", Page: "305"},
+			{Title: "Sandworm", Text: "Lights out", Page: "310"},
+		},
+		"Dune": {{Title: "Dune", Text: "Fear is the mind-killer", Page: "8"}},
+	})
+	require.NoError(t, err, "Should preview without error")
+
+	assert.Len(t, mockFS.files, 1, "Should not write anything")
+	assert.Empty(t, mockFS.dirs, "Should not create directories")
+
+	require.Len(t, preview.Results, 2)
+	assert.Equal(t, 1, preview.Results[1].NewCount, "Should report what the export would do")
+
+	created, modified, deleted := preview.Counts()
+	assert.Equal(t, 1, created, "Should create the new note, leaving out the sync state")
+	assert.Equal(t, 1, modified)
+	assert.Zero(t, deleted)
+
+	var sandworm FileChange
+	for _, change := range preview.Changes {
+		if change.Path == "/home/user/notes/Sandworm.md" {
+			sandworm = change
+		}
+	}
+	expected := "--- /home/user/notes/Sandworm.md\n" +
+		"+++ /home/user/notes/Sandworm.md\n" +
+		"@@ -1,3 +1,4 @@\n" +
+		" # Sandworm\n" +
+		" \n" +
+		" - Cascading failures (Page: 305)\n" +
+		"+- Lights out (Page: 310)\n"
+	assert.Equal(t, expected, sandworm.Diff())
+}
+
+func TestFileChangeBinaryDiff(t *testing.T) {
+	change := FileChange{Path: "deck.apkg", Created: true, After: "PK\x03\x04\x00"}
+	assert.Equal(t, "Binary files /dev/null and deck.apkg differ\n", change.Diff())
+}
+
+func TestExportHighlightsBackups(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", Backups: 2}
+	mockFS := NewMockFileSystem()
+
+	notePath := "/home/user/notes/Sandworm.md"
+	statePath := "/home/user/notes/.kindle-highlights/state.json"
+	original := "# Sandworm\n\nMy own notes\n"
+	mockFS.files[notePath] = []byte(original)
+
+	now := time.Date(2026, 3, 1, 9, 30, 0, 0, time.UTC)
+	export := func(highlights ...models.Highlight) {
+		service := NewWithFileSystem(cfg, mockFS)
+		service.now = func() time.Time { return now }
+		_, err := service.ExportHighlights(map[string][]models.Highlight{"Sandworm": highlights})
+		require.NoError(t, err, "Should export without error")
+	}
+	highlight := func(text string) models.Highlight {
+		return models.Highlight{Title: "Sandworm", Text: text, Page: "1", Location: "1-2"}
+	}
+
+	export(highlight("First"))
+	assert.Equal(t, []byte(original), mockFS.files["/home/user/notes/.kindle-highlights/backups/20260301T093000Z/001-Sandworm.md"], "Should back up the note before modifying it")
+
+	var index backupIndex
+	require.NoError(t, json.Unmarshal(mockFS.files["/home/user/notes/.kindle-highlights/backups/index.json"], &index))
+	require.Len(t, index.Runs, 1)
+	assert.Equal(t, []backedUpFile{
+		{Path: notePath, Backup: "001-Sandworm.md"},
+		{Path: statePath, Created: true},
+	}, index.Runs[0].Files)
+
+	// A second run within the same second keeps its own backups
+	export(highlight("First"), highlight("Second"))
+	afterFirst := mockFS.files["/home/user/notes/.kindle-highlights/backups/20260301T093000Z-2/001-Sandworm.md"]
+	assert.Equal(t, "# Sandworm\n\nMy own notes\n- First (Page: 1)\n", string(afterFirst))
+
+	// Nothing new is written, so no run is recorded
+	export(highlight("First"), highlight("Second"))
+
+	now = now.Add(time.Hour)
+	export(highlight("First"), highlight("Second"), highlight("Third"))
+	assert.NotContains(t, mockFS.files, "/home/user/notes/.kindle-highlights/backups/20260301T093000Z/001-Sandworm.md", "Should drop runs beyond the configured number")
+
+	restore := func() RestoreResult {
+		result, err := NewWithFileSystem(cfg, mockFS).Restore()
+		require.NoError(t, err, "Should restore without error")
+		return result
+	}
+
+	result := restore()
+	assert.Equal(t, "20260301T103000Z", result.RunID)
+	assert.Equal(t, []string{notePath, statePath}, result.Restored)
+	assert.Equal(t, "# Sandworm\n\nMy own notes\n- First (Page: 1)\n- Second (Page: 1)\n", string(mockFS.files[notePath]))
+
+	result = restore()
+	assert.Equal(t, "20260301T093000Z-2", result.RunID)
+	assert.Equal(t, string(afterFirst), string(mockFS.files[notePath]))
+
+	_, err := NewWithFileSystem(cfg, mockFS).Restore()
+	assert.ErrorIs(t, err, ErrNoBackups, "Should keep only the configured number of runs")
+}
+
+func TestExportHighlightsBackupsDisabled(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes"}
+	mockFS := NewMockFileSystem()
+
+	_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{
+		"Sandworm": {{Title: "Sandworm", Text: "Cascading failures", Page: "305"}},
+	})
+	require.NoError(t, err, "Should export without error")
+
+	for path := range mockFS.files {
+		assert.NotContains(t, path, "/backups/", "Should not back up anything")
+	}
+}
+
+func TestRestoreRemovesCreatedFiles(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", Backups: 10}
+	mockFS := NewMockFileSystem()
+
+	_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{
+		"Sandworm": {{Title: "Sandworm", Text: "Cascading failures", Page: "305"}},
+	})
+	require.NoError(t, err, "Should export without error")
+
+	result, err := NewWithFileSystem(cfg, mockFS).Restore()
+	require.NoError(t, err, "Should restore without error")
+	assert.Empty(t, result.Restored)
+	assert.Equal(t, []string{"/home/user/notes/Sandworm.md", "/home/user/notes/.kindle-highlights/state.json"}, result.Removed)
+	assert.NotContains(t, mockFS.files, "/home/user/notes/Sandworm.md")
+	assert.NotContains(t, mockFS.files, "/home/user/notes/.kindle-highlights/state.json")
+}
+
+func TestOSFileSystemWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "Sandworm.md")
+	fs := OSFileSystem{}
+
+	require.NoError(t, os.WriteFile(filename, []byte("old content that is longer\n"), 0600))
+	require.NoError(t, fs.WriteFile(filename, []byte("new\n"), 0644))
+
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "new\n", string(content), "Should replace the whole file")
+
+	info, err := os.Stat(filename)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "Should keep the permissions of an existing file")
+
+	created := filepath.Join(dir, "Dune.md")
+	require.NoError(t, fs.WriteFile(created, []byte("new\n"), 0644))
+	info, err = os.Stat(created)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm(), "Should give new files the requested permissions")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "Should not leave temporary files behind")
+}
+
+func TestExportHighlightsErrorPolicies(t *testing.T) {
+	bookHighlights := map[string][]models.Highlight{
+		"Alpha": {{Title: "Alpha", Text: "First", Page: "1"}},
+		"Beta":  {{Title: "Beta", Text: "Second", Page: "2"}},
+		"Gamma": {{Title: "Gamma", Text: "Third", Page: "3"}},
+	}
+	errDiskFull := errors.New("disk full")
+
+	tests := []struct {
+		name         string
+		onError      string
+		wantTitles   []string
+		wantWritten  []string
+		wantNotFound []string
+	}{
+		{
+			name:         "stop keeps the books before the failure",
+			onError:      "",
+			wantTitles:   []string{"Alpha", "Beta"},
+			wantWritten:  []string{"Alpha"},
+			wantNotFound: []string{"Gamma"},
+		},
+		{
+			name:        "continue exports the remaining books",
+			onError:     OnErrorContinue,
+			wantTitles:  []string{"Alpha", "Beta", "Gamma"},
+			wantWritten: []string{"Alpha", "Gamma"},
+		},
+		{
+			name:         "rollback writes nothing",
+			onError:      OnErrorRollback,
+			wantTitles:   []string{"Alpha", "Beta", "Gamma"},
+			wantNotFound: []string{"Alpha", "Gamma", ".kindle-highlights/state"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// One worker, so stopping never races books already started
+			cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", OnError: tt.onError, Workers: 1}
+			mockFS := NewMockFileSystem()
+			mockFS.writeErrors = map[string]error{"/home/user/notes/Beta.md": errDiskFull}
+
+			results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(bookHighlights)
+			require.ErrorIs(t, err, errDiskFull)
+
+			var titles []string
+			for _, result := range results {
+				titles = append(titles, result.BookTitle)
+			}
+			assert.Equal(t, tt.wantTitles, titles, "Should report every book attempted")
+
+			failed := results[1]
+			assert.ErrorIs(t, failed.Err, errDiskFull, "Should record the error on the failing book")
+			assert.Equal(t, "/home/user/notes/Beta.md", failed.FilePath)
+			assert.Zero(t, failed.NewCount)
+
+			for _, title := range tt.wantWritten {
+				assert.Contains(t, mockFS.files, "/home/user/notes/"+title+".md")
+			}
+			for _, title := range tt.wantNotFound {
+				assert.NotContains(t, mockFS.files, "/home/user/notes/"+title+".md")
+			}
+		})
+	}
+}
+
+func TestExportHighlightsResultIDs(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes"}
+	mockFS := NewMockFileSystem()
+
+	first := models.Highlight{Title: "Sandworm", Text: "Cascading failures", Page: "305"}
+	second := models.Highlight{Title: "Sandworm", Text: "Lights out", Page: "306"}
+
+	_, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {first}})
+	require.NoError(t, err, "Should export without error")
+
+	results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": {first, second}})
+	require.NoError(t, err, "Should export without error")
+	require.Len(t, results, 1)
+	assert.Equal(t, "/home/user/notes/Sandworm.md", results[0].FilePath)
+	assert.Equal(t, []string{second.ID()}, results[0].WrittenIDs)
+	assert.Equal(t, []string{first.ID()}, results[0].SkippedIDs)
+	assert.NoError(t, results[0].Err)
+}
+
+func TestExportHighlightsRollbackCommits(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", OnError: OnErrorRollback, Backups: 1}
+	mockFS := NewMockFileSystem()
+	mockFS.files["/home/user/notes/Alpha.md"] = []byte("# Alpha\n\n")
+
+	results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{
+		"Alpha": {{Title: "Alpha", Text: "First", Page: "1"}},
+		"Beta":  {{Title: "Beta", Text: "Second", Page: "2"}},
+	})
+	require.NoError(t, err, "Should export without error")
+	require.Len(t, results, 2)
+	assert.Equal(t, "# Alpha\n\n- First (Page: 1)\n", string(mockFS.files["/home/user/notes/Alpha.md"]))
+	assert.Contains(t, mockFS.files, "/home/user/notes/Beta.md")
+	assert.Contains(t, mockFS.files, "/home/user/notes/.kindle-highlights/state.json")
+
+	restored, err := NewWithFileSystem(cfg, mockFS).Restore()
+	require.NoError(t, err, "Should back up the committed writes")
+	assert.Equal(t, []string{"/home/user/notes/Alpha.md"}, restored.Restored)
+}
+
+func TestExportHighlightsUnknownErrorPolicy(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", OnError: "ignore"}
+	_, err := NewWithFileSystem(cfg, NewMockFileSystem()).ExportHighlights(map[string][]models.Highlight{
+		"Sandworm": {{Title: "Sandworm", Text: "Cascading failures", Page: "305"}},
+	})
+	assert.ErrorContains(t, err, `unknown error policy "ignore"`)
+}
+
+func TestBuildFilePath(t *testing.T) {
+	book := models.BookGroup{Title: "Sandworm: A New Era", Author: "Andy Greenberg"}
+	id := book.ID()[:filenameIDLength]
+
+	tests := []struct {
+		name      string
+		filenames string
+		book      models.BookGroup
+		expected  string
+	}{
+		{name: "title by default", book: book, expected: "/home/user/notes/Sandworm- A New Era.md"},
+		{name: "title", filenames: FilenameTitle, book: book, expected: "/home/user/notes/Sandworm- A New Era.md"},
+		{name: "author and title", filenames: FilenameAuthorTitle, book: book, expected: "/home/user/notes/Andy Greenberg - Sandworm- A New Era.md"},
+		{name: "author and title without author", filenames: FilenameAuthorTitle, book: models.BookGroup{Title: "Sandworm"}, expected: "/home/user/notes/Sandworm.md"},
+		{name: "slug", filenames: FilenameSlug, book: book, expected: "/home/user/notes/sandworm-a-new-era.md"},
+		{name: "ID suffixed", filenames: FilenameID, book: book, expected: "/home/user/notes/Sandworm- A New Era-" + id + ".md"},
+		{name: "author folder", filenames: FilenameAuthorFolder, book: book, expected: "/home/user/notes/Andy Greenberg/Sandworm- A New Era.md"},
+		{name: "title without usable characters", book: models.BookGroup{Title: "???"}, expected: "/home/user/notes/" + models.BookGroup{Title: "???"}.ID() + ".md"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", Filenames: tt.filenames}
+			service := NewWithFileSystem(cfg, NewMockFileSystem())
+			assert.Equal(t, tt.expected, service.buildFilePath(tt.book, markdownExtension, ""))
+		})
+	}
+}
+
+func TestExportHighlightsFilenameCollisions(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes"}
+	mockFS := NewMockFileSystem()
+
+	slash := models.Highlight{Title: "A/B", Text: "Slash", Page: "1"}
+	colon := models.Highlight{Title: "A:B", Text: "Colon", Page: "1"}
+	colonPath := "/home/user/notes/A-B-" + models.BookGroup{Title: "A:B"}.ID()[:filenameIDLength] + ".md"
+
+	results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{
+		"A/B": {slash},
+		"A:B": {colon},
+	})
+	require.NoError(t, err, "Should export without error")
+	require.Len(t, results, 2)
+	assert.Equal(t, "/home/user/notes/A-B.md", results[0].FilePath)
+	assert.Equal(t, colonPath, results[1].FilePath, "Should give the second book its own note")
+	assert.Equal(t, "# A/B\n\n- Slash (Page: 1)\n", string(mockFS.files["/home/user/notes/A-B.md"]))
+	assert.Equal(t, "# A:B\n\n- Colon (Page: 1)\n", string(mockFS.files[colonPath]))
+
+	// The sync state remembers which book owns a note across exports
+	results, err = NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"A:B": {colon}})
+	require.NoError(t, err, "Should export without error")
+	assert.Equal(t, colonPath, results[0].FilePath)
+	assert.Equal(t, 1, results[0].SkippedCount)
+	assert.Equal(t, "# A/B\n\n- Slash (Page: 1)\n", string(mockFS.files["/home/user/notes/A-B.md"]), "Should never merge two books")
+}
+
+func TestExportHighlightsUnknownFilenames(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", Filenames: "isbn"}
+	_, err := NewWithFileSystem(cfg, NewMockFileSystem()).ExportHighlights(map[string][]models.Highlight{
+		"Sandworm": {{Title: "Sandworm", Text: "Cascading failures", Page: "305"}},
+	})
+	assert.ErrorContains(t, err, `unknown filename strategy "isbn"`)
+}
+
+func TestExportHighlightsBookOverrides(t *testing.T) {
+	dictionary := models.Highlight{Title: "Oxford Dictionary", Text: "Lexicon", Page: "1"}
+	cfg := &config.Config{
+		HomeDir:        "/home/user",
+		NotesDirectory: "notes",
+		FrontMatter:    config.FrontMatter{Enabled: true, Source: "kindle", Tags: []string{"kindle"}},
+		Books: []config.Book{
+			{Match: "sandworm", Filename: "Cyberwar", Folder: "security/../reading", Tags: []string{"security", "kindle"}, Title: "Sandworm (2019)"},
+			{Match: models.BookGroup{Title: dictionary.Title}.ID(), Ignore: true},
+		},
+	}
+	mockFS := NewMockFileSystem()
+
+	results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{
+		"Sandworm":          {{Title: "Sandworm", Text: "Cascading failures", Page: "305"}},
+		"Oxford Dictionary": {dictionary},
+	})
+	require.NoError(t, err, "Should export without error")
+	require.Len(t, results, 1, "Should leave out ignored books")
+
+	notePath := "/home/user/notes/security/reading/Cyberwar.md"
+	assert.Equal(t, notePath, results[0].FilePath, "Should name the note by its overrides")
+	assert.Equal(t, "Sandworm", results[0].BookTitle)
+
+	content := string(mockFS.files[notePath])
+	assert.Contains(t, content, "title: Sandworm (2019)\n")
+	assert.Contains(t, content, "tags:\n  - kindle\n  - security\n", "Should add the book's tags once")
+	assert.Contains(t, content, "# Sandworm (2019)\n")
+	assert.NotContains(t, mockFS.files, "/home/user/notes/Oxford Dictionary.md")
+
+	// A display title does not change which book owns the note
+	results, err = NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{
+		"Sandworm": {{Title: "Sandworm", Text: "Cascading failures", Page: "305"}},
+	})
+	require.NoError(t, err, "Should export without error")
+	assert.Equal(t, notePath, results[0].FilePath)
+	assert.Equal(t, 1, results[0].SkippedCount)
+}
+
+func TestExportHighlightsMirror(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", ManagedRegions: true, Mirror: true}
+	path := "/home/user/notes/Sandworm.md"
+	mockFS := NewMockFileSystem()
+
+	first := models.Highlight{Title: "Sandworm", Text: "Cascading failures", Page: "305", Location: "4933-4934"}
+	second := models.Highlight{Title: "Sandworm", Text: "Lights out", Page: "310", Location: "5001-5002"}
+	third := models.Highlight{Title: "Sandworm", Text: "NotPetya", Page: "320", Location: "5101-5102"}
+
+	export := func(highlights ...models.Highlight) models.ExportResult {
+		results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(map[string][]models.Highlight{"Sandworm": highlights})
+		require.NoError(t, err, "Should export without error")
+		require.Len(t, results, 1)
+		return results[0]
+	}
+
+	export(first, second, third)
+
+	// The user comments on the first highlight and edits the third
+	content := string(mockFS.files[path])
+	content = strings.Replace(content, "<!-- kindle-highlights:end id="+first.ID()+" -->\n", "<!-- kindle-highlights:end id="+first.ID()+" -->\nMy commentary\n", 1)
+	content = strings.Replace(content, "- NotPetya (Page: 320)\n", "- NotPetya (Page: 320) — see also WannaCry\n", 1)
+	mockFS.files[path] = []byte(content)
+
+	result := export(first)
+	assert.Equal(t, 1, result.RemovedCount)
+	assert.Equal(t, []string{second.ID()}, result.RemovedIDs)
+	assert.Equal(t, []models.Conflict{{HighlightID: third.ID(), Reason: conflictKept}}, result.Conflicts, "Should keep edited blocks")
+
+	content = string(mockFS.files[path])
+	assert.NotContains(t, content, "Lights out")
+	assert.Contains(t, content, "My commentary\n", "Should keep text outside the markers")
+	assert.Contains(t, content, "- NotPetya (Page: 320) — see also WannaCry\n")
+
+	var state syncState
+	require.NoError(t, json.Unmarshal(mockFS.files["/home/user/notes/.kindle-highlights/state.json"], &state))
+	assert.Equal(t, []string{first.ID(), third.ID()}, state.Files["Sandworm.md"].Highlights, "Should stop tracking removed highlights")
+
+	// A highlight that comes back is exported again
+	result = export(first, second)
+	assert.Equal(t, 1, result.NewCount)
+	assert.Contains(t, string(mockFS.files[path]), "- Lights out (Page: 310)\n")
+}
+
+func TestExportHighlightsMirrorNeedsManagedRegions(t *testing.T) {
+	cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", Mirror: true}
+	_, err := NewWithFileSystem(cfg, NewMockFileSystem()).ExportHighlights(map[string][]models.Highlight{
+		"Sandworm": {{Title: "Sandworm", Text: "Cascading failures", Page: "305"}},
+	})
+	assert.ErrorContains(t, err, "mirror mode needs managed_regions")
+}
+
+// concurrentBooks returns books highlights each, with titles that sort in
+// the order they are numbered.
+func concurrentBooks(books, highlights int) map[string][]models.Highlight {
+	bookHighlights := make(map[string][]models.Highlight, books)
+	for i := range books {
+		title := fmt.Sprintf("Book %03d", i)
+		for j := range highlights {
+			bookHighlights[title] = append(bookHighlights[title], models.Highlight{
+				Title: title, Author: "Author", Text: fmt.Sprintf("Highlight %d", j), Page: strconv.Itoa(j + 1),
+			})
+		}
+	}
+	return bookHighlights
+}
+
+func TestExportHighlightsWorkers(t *testing.T) {
+	bookHighlights := concurrentBooks(40, 3)
+	// Sanitized to the same name; the first in title order keeps it
+	bookHighlights["A/B"] = []models.Highlight{{Title: "A/B", Text: "Slash", Page: "1"}}
+	bookHighlights["A:B"] = []models.Highlight{{Title: "A:B", Text: "Colon", Page: "1"}}
+
+	tests := []struct {
+		name    string
+		onError string
+	}{
+		{name: "stop", onError: OnErrorStop},
+		{name: "continue", onError: OnErrorContinue},
+		{name: "rollback", onError: OnErrorRollback},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", OnError: tt.onError, Backups: 10, Workers: 8}
+			mockFS := NewMockFileSystem()
+
+			results, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(bookHighlights)
+			require.NoError(t, err, "Should export without error")
+
+			titles := make([]string, 0, len(results))
+			for _, result := range results {
+				titles = append(titles, result.BookTitle)
+				assert.Contains(t, mockFS.files, result.FilePath)
+			}
+			assert.Equal(t, sortedTitles(bookHighlights), titles, "Should report the books in title order")
+			assert.Equal(t, "/home/user/notes/A-B.md", results[0].FilePath)
+			assert.NotEqual(t, results[0].FilePath, results[1].FilePath, "Should give colliding books their own notes")
+
+			var state syncState
+			require.NoError(t, json.Unmarshal(mockFS.files["/home/user/notes/.kindle-highlights/state.json"], &state))
+			assert.Len(t, state.Files, len(bookHighlights), "Should track every book")
+
+			var index backupIndex
+			require.NoError(t, json.Unmarshal(mockFS.files["/home/user/notes/.kindle-highlights/backups/index.json"], &index))
+			require.Len(t, index.Runs, 1)
+			assert.Len(t, index.Runs[0].Files, len(bookHighlights)+1, "Should record every created note and the state")
+		})
+	}
+}
+
+// BenchmarkExportHighlights exports 100 books to a file system that takes a
+// millisecond per read and write, with more workers each time.
+func BenchmarkExportHighlights(b *testing.B) {
+	bookHighlights := concurrentBooks(100, 20)
+
+	for _, workers := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			cfg := &config.Config{HomeDir: "/home/user", NotesDirectory: "notes", Backups: 10, Workers: workers}
+			for range b.N {
+				mockFS := NewMockFileSystem()
+				mockFS.latency = time.Millisecond
+				if _, err := NewWithFileSystem(cfg, mockFS).ExportHighlights(bookHighlights); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
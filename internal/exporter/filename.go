@@ -0,0 +1,147 @@
+package exporter
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+// Filename strategies name the note written for each book.
+const (
+	FilenameTitle        = "title"         // Sandworm.md
+	FilenameAuthorTitle  = "author-title"  // Andy Greenberg - Sandworm.md
+	FilenameSlug         = "slug"          // sandworm.md
+	FilenameID           = "id"            // Sandworm-1a2b3c4d.md
+	FilenameAuthorFolder = "author-folder" // Andy Greenberg/Sandworm.md
+)
+
+const (
+	// maxFilenameBytes leaves room for an extension within the usual limit
+	// of 255 bytes per name
+	maxFilenameBytes = 200
+	filenameIDLength = 8
+)
+
+func (s *Service) validateFilenames() error {
+	switch s.config.Filenames {
+	case "", FilenameTitle, FilenameAuthorTitle, FilenameSlug, FilenameID, FilenameAuthorFolder:
+		return nil
+	}
+	return fmt.Errorf("unknown filename strategy %q: expected %s, %s, %s, %s or %s", s.config.Filenames,
+		FilenameTitle, FilenameAuthorTitle, FilenameSlug, FilenameID, FilenameAuthorFolder)
+}
+
+// noteName names the book's note by the configured strategy or its
+// overrides, as a slash separated path relative to the notes directory
+// without an extension. Books whose name sanitizes to nothing are named by
+// their ID.
+func (s *Service) noteName(book models.BookGroup) string {
+	overrides := s.bookOverrides(book)
+	display := s.displayBook(book).Title
+	title := s.sanitizeFilename(display)
+	author := s.sanitizeFilename(book.Author)
+
+	name := title
+	switch s.config.Filenames {
+	case FilenameAuthorTitle:
+		if author != "" {
+			name = s.sanitizeFilename(book.Author + " - " + display)
+		}
+	case FilenameSlug:
+		name = truncateName(slugify(display), maxFilenameBytes)
+	case FilenameID:
+		name = withBookID(title, book, filenameIDLength)
+	case FilenameAuthorFolder:
+		if author != "" && title != "" {
+			name = author + "/" + title
+		}
+	}
+
+	if overrides.Filename != "" {
+		name = s.sanitizeFilename(overrides.Filename)
+	}
+	if name == "" {
+		name = book.ID()
+	}
+	if folder := s.sanitizeFolder(overrides.Folder); folder != "" {
+		name = folder + "/" + path.Base(name)
+	}
+	return name
+}
+
+// withBookID appends the first n characters of the book's ID to the last
+// element of name.
+func withBookID(name string, book models.BookGroup, n int) string {
+	dir, base := path.Split(name)
+	id := book.ID()[:n]
+	if base == "" {
+		return dir + id
+	}
+	return dir + truncateName(base, maxFilenameBytes-len(id)-1) + "-" + id
+}
+
+// buildFilePath returns the path of the book's note, in subdirectory of the
+// notes directory when given. A book whose note name is already taken by
+// another book, earlier in this export or in the sync state, gets its ID
+// appended instead, so two books never share a note.
+func (s *Service) buildFilePath(book models.BookGroup, extension, subdirectory string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := s.noteName(book)
+	candidates := []string{name, withBookID(name, book, filenameIDLength), withBookID(name, book, len(book.ID()))}
+
+	var filename string
+	for _, candidate := range candidates {
+		filename = filepath.Join(s.notesRoot(), subdirectory, filepath.FromSlash(candidate)+extension)
+		if !s.takenByOtherBook(filename, book) {
+			break
+		}
+	}
+
+	if s.claimed == nil {
+		s.claimed = make(map[string]string)
+	}
+	s.claimed[strings.ToLower(filename)] = book.ID()
+	return filename
+}
+
+// takenByOtherBook reports whether another book's note is at filename.
+// Paths are compared case-insensitively, as some file systems do. The
+// caller holds s.mu.
+func (s *Service) takenByOtherBook(filename string, book models.BookGroup) bool {
+	if owner, ok := s.claimed[strings.ToLower(filename)]; ok {
+		return owner != book.ID()
+	}
+
+	if s.state == nil {
+		return false
+	}
+	tracked, ok := s.state.file(s.stateKey(filename))
+	if !ok || tracked.BookID == "" || tracked.BookID == book.ID() {
+		return false
+	}
+	_, err := s.fs.Stat(filename)
+	return err == nil
+}
+
+// linkName is the wikilink target of the note at filename.
+func (s *Service) linkName(filename string) string {
+	return strings.TrimSuffix(s.stateKey(filename), filepath.Ext(filename))
+}
+
+// truncateName cuts name to at most max bytes without splitting a rune.
+func truncateName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+
+	for max > 0 && !utf8.RuneStart(name[max]) {
+		max--
+	}
+	return strings.TrimSpace(name[:max])
+}
@@ -0,0 +1,86 @@
+package exporter
+
+import (
+	"io"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+type Format string
+
+const (
+	FormatMarkdown Format = "markdown"
+	FormatObsidian Format = "obsidian"
+	FormatLogseq   Format = "logseq"
+	FormatOrg      Format = "org"
+	FormatReadwise Format = "readwise"
+	FormatRoam     Format = "roam"
+	FormatJSON     Format = "json"
+	FormatCSV      Format = "csv"
+	FormatHTML     Format = "html"
+	FormatEPUB     Format = "epub"
+	FormatAnki     Format = "anki"
+	FormatAPKG     Format = "apkg"
+	FormatZettel   Format = "zettelkasten"
+)
+
+const defaultExportBasename = "kindle-highlights"
+
+// singleFileWriter renders the whole selection into one file instead of one
+// note per book.
+type singleFileWriter struct {
+	extension string
+	write     func(w io.Writer, highlights []models.Highlight) error
+}
+
+var singleFileWriters = map[Format]singleFileWriter{
+	FormatReadwise: {extension: readwiseExtension, write: WriteReadwiseCSV},
+	FormatRoam:     {extension: roamExtension, write: WriteRoamJSON},
+	FormatJSON:     {extension: jsonExtension, write: WriteLibraryJSON},
+	FormatCSV:      {extension: csvExtension, write: WriteLibraryCSV},
+	FormatEPUB:     {extension: epubExtension, write: WriteEPUB},
+}
+
+// singleFileWriter looks up the writer for format, binding the options of
+// writers that depend on the config.
+func (s *Service) singleFileWriter(format Format) (singleFileWriter, bool) {
+	switch format {
+	case FormatAnki, FormatAPKG:
+		options := AnkiOptions{Deck: s.config.Anki.Deck, Cloze: s.config.Anki.Cloze}
+		if format == FormatAnki {
+			return singleFileWriter{extension: ankiExtension, write: func(w io.Writer, highlights []models.Highlight) error {
+				return WriteAnkiText(w, highlights, options)
+			}}, true
+		}
+		return singleFileWriter{extension: apkgExtension, write: func(w io.Writer, highlights []models.Highlight) error {
+			return WriteAnkiPackage(w, highlights, options)
+		}}, true
+	}
+
+	writer, ok := singleFileWriters[format]
+	return writer, ok
+}
+
+// Formats lists every export format the Service understands.
+func Formats() []Format {
+	return []Format{FormatMarkdown, FormatObsidian, FormatLogseq, FormatOrg, FormatReadwise, FormatRoam, FormatJSON, FormatCSV, FormatHTML, FormatEPUB, FormatAnki, FormatAPKG, FormatZettel}
+}
+
+// groupBooks groups highlights by title for single-file writers, keeping books
+// and highlights in the order they appear.
+func groupBooks(highlights []models.Highlight) []models.BookGroup {
+	var books []models.BookGroup
+	bookIndex := make(map[string]int)
+
+	for _, highlight := range highlights {
+		i, ok := bookIndex[highlight.Title]
+		if !ok {
+			i = len(books)
+			bookIndex[highlight.Title] = i
+			books = append(books, models.BookGroup{Title: highlight.Title, Author: highlight.Author})
+		}
+		books[i].Highlights = append(books[i].Highlights, highlight)
+	}
+
+	return books
+}
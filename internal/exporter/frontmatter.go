@@ -0,0 +1,183 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/parser"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const (
+	frontMatterDelimiter  = "---\n"
+	frontMatterDateFormat = "2006-01-02"
+
+	// Keys refreshed on every export; the rest are only added when missing
+	highlightCountKey = "highlights"
+	firstDateKey      = "first_highlighted"
+	lastDateKey       = "last_highlighted"
+)
+
+// applyFrontMatter creates or refreshes the YAML front matter at the top of
+// content. Counts and dates are updated in place; any other key, including
+// ones the user added, is left as it is.
+func (s *Service) applyFrontMatter(content string, book models.BookGroup, tags []string, newHighlights []models.Highlight, skippedCount, removedCount int) (string, error) {
+	block, body := splitFrontMatter(content)
+
+	mapping, err := parseFrontMatter(block)
+	if err != nil {
+		return "", fmt.Errorf("parsing front matter: %w", err)
+	}
+
+	count := skippedCount
+	if node := findKey(mapping, highlightCountKey); node != nil {
+		if previous, err := strconv.Atoi(node.Value); err == nil {
+			count = previous
+		}
+	}
+	count += len(newHighlights) - removedCount
+
+	first, last := dateRange(newHighlights)
+	if node := findKey(mapping, firstDateKey); node != nil {
+		if previous, err := time.Parse(frontMatterDateFormat, node.Value); err == nil && (first.IsZero() || previous.Before(first)) {
+			first = previous
+		}
+	}
+	if node := findKey(mapping, lastDateKey); node != nil {
+		if previous, err := time.Parse(frontMatterDateFormat, node.Value); err == nil && previous.After(last) {
+			last = previous
+		}
+	}
+
+	setDefault(mapping, "title", scalarNode(book.Title))
+	setDefault(mapping, "authors", sequenceNode(splitAuthors(book.Author)))
+	setKey(mapping, highlightCountKey, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(count)})
+	if !first.IsZero() {
+		setKey(mapping, firstDateKey, scalarNode(first.Format(frontMatterDateFormat)))
+		setKey(mapping, lastDateKey, scalarNode(last.Format(frontMatterDateFormat)))
+	}
+	setDefault(mapping, "source", scalarNode(s.config.FrontMatter.Source))
+	setDefault(mapping, "tags", sequenceNode(tags))
+
+	var encoded bytes.Buffer
+	encoder := yaml.NewEncoder(&encoded)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(mapping); err != nil {
+		return "", fmt.Errorf("encoding front matter: %w", err)
+	}
+
+	return frontMatterDelimiter + encoded.String() + frontMatterDelimiter + body, nil
+}
+
+// splitFrontMatter separates a leading "---" delimited block from the rest
+// of content. The block is empty when content has no front matter.
+func splitFrontMatter(content string) (string, string) {
+	if !strings.HasPrefix(content, frontMatterDelimiter) {
+		return "", content
+	}
+
+	rest := content[len(frontMatterDelimiter):]
+	if strings.HasPrefix(rest, frontMatterDelimiter) {
+		return "", rest[len(frontMatterDelimiter):]
+	}
+
+	end := strings.Index(rest, "\n"+frontMatterDelimiter)
+	if end < 0 {
+		return "", content
+	}
+
+	return rest[:end+1], rest[end+1+len(frontMatterDelimiter):]
+}
+
+func parseFrontMatter(block string) (*yaml.Node, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(block), &document); err != nil {
+		return nil, err
+	}
+
+	if document.Kind == 0 || len(document.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+
+	mapping := document.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("front matter is not a mapping")
+	}
+
+	return mapping, nil
+}
+
+func findKey(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func setKey(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			value.HeadComment = mapping.Content[i+1].HeadComment
+			value.LineComment = mapping.Content[i+1].LineComment
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, scalarNode(key), value)
+}
+
+func setDefault(mapping *yaml.Node, key string, value *yaml.Node) {
+	if findKey(mapping, key) == nil {
+		setKey(mapping, key, value)
+	}
+}
+
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+func sequenceNode(values []string) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	for _, value := range values {
+		node.Content = append(node.Content, scalarNode(value))
+	}
+	return node
+}
+
+// splitAuthors splits the Kindle author field, which separates co-authors
+// with semicolons.
+func splitAuthors(author string) []string {
+	var authors []string
+	for _, name := range strings.Split(author, ";") {
+		if name = strings.TrimSpace(name); name != "" {
+			authors = append(authors, name)
+		}
+	}
+	return authors
+}
+
+func dateRange(highlights []models.Highlight) (time.Time, time.Time) {
+	var first, last time.Time
+
+	for _, highlight := range highlights {
+		added, err := parser.ParseDate(highlight.Date)
+		if err != nil {
+			continue
+		}
+		if first.IsZero() || added.Before(first) {
+			first = added
+		}
+		if added.After(last) {
+			last = added
+		}
+	}
+
+	return first, last
+}
@@ -0,0 +1,86 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/schema"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const (
+	jsonExtension = ".json"
+	csvExtension  = ".csv"
+
+	// libraryKind identifies full-library exports in the JSON schema
+	libraryKind = "library"
+)
+
+var libraryCSVHeader = []string{
+	"id", "book_id", "title", "author", "text", "note", "page",
+	"location", "location_start", "location_end", "date", "added_at",
+}
+
+// WriteLibraryJSON writes highlights grouped by book as a versioned schema
+// document, see docs/json-schema.md.
+func WriteLibraryJSON(w io.Writer, highlights []models.Highlight) error {
+	books := make([]schema.Book, 0)
+	for _, book := range groupBooks(highlights) {
+		books = append(books, schema.FromBook(book, true))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(schema.Document{
+		SchemaVersion: schema.Version,
+		Kind:          libraryKind,
+		Data:          books,
+	})
+}
+
+// WriteLibraryCSV writes one row per highlight with every highlight field,
+// its book ID and the parsed location and date.
+func WriteLibraryCSV(w io.Writer, highlights []models.Highlight) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(libraryCSVHeader); err != nil {
+		return err
+	}
+
+	for _, highlight := range highlights {
+		converted := schema.FromHighlight(highlight)
+
+		var start, end, added string
+		if converted.Location != nil {
+			start = strconv.Itoa(converted.Location.Start)
+			end = strconv.Itoa(converted.Location.End)
+		}
+		if converted.AddedAt != nil {
+			added = converted.AddedAt.Format(time.RFC3339)
+		}
+
+		record := []string{
+			converted.ID,
+			converted.BookID,
+			highlight.Title,
+			highlight.Author,
+			highlight.Text,
+			highlight.Note,
+			highlight.Page,
+			highlight.Location,
+			start,
+			end,
+			highlight.Date,
+			added,
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,261 @@
+package exporter
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/parser"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const (
+	managedBegin = "kindle-highlights:begin id="
+	managedEnd   = "kindle-highlights:end id="
+
+	// Reasons reported for managed blocks left alone
+	conflictEdited    = "edited by hand and changed in the source"
+	conflictNoEndMark = "end marker missing"
+	conflictKept      = "edited by hand and deleted in the source"
+)
+
+// commentStyle wraps a marker in a comment line of the note's syntax.
+type commentStyle struct {
+	prefix, suffix string
+}
+
+var (
+	htmlComment = commentStyle{prefix: "<!-- ", suffix: " -->"}
+	orgComment  = commentStyle{prefix: "# "}
+)
+
+func (c commentStyle) line(text string) string {
+	return c.prefix + text + c.suffix + "\n"
+}
+
+// validateMirror checks that removed highlights can be found: only managed
+// regions mark which lines belong to which highlight.
+func (s *Service) validateMirror() error {
+	if !s.config.Mirror {
+		return nil
+	}
+	if s.format() == FormatZettel {
+		return fmt.Errorf("mirror mode is not supported by the %s format", FormatZettel)
+	}
+	if !s.config.ManagedRegions {
+		return fmt.Errorf("mirror mode needs managed_regions, to know which lines it wrote")
+	}
+	return nil
+}
+
+// managedRenderer wraps every highlight in begin and end markers carrying its
+// ID, location and a checksum of the block as written. Re-exports can then
+// refresh blocks that changed in the source without touching anything
+// outside the markers, or blocks the user edited.
+type managedRenderer struct {
+	bookRenderer
+	comment commentStyle
+	beginRe *regexp.Regexp
+}
+
+// managedBlock is a marked highlight found in a note. The content lies
+// between contentStart and contentEnd; end is -1 when the end marker is
+// missing.
+type managedBlock struct {
+	id           string
+	location     int
+	sum          string
+	start        int
+	contentStart int
+	contentEnd   int
+	end          int
+}
+
+func newManagedRenderer(renderer bookRenderer, comment commentStyle) managedRenderer {
+	return managedRenderer{
+		bookRenderer: renderer,
+		comment:      comment,
+		beginRe: regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(comment.prefix+managedBegin) +
+			`([0-9a-f]+)(?: loc=(\d+))? sum=([0-9a-f]+)` + regexp.QuoteMeta(comment.suffix) + `$`),
+	}
+}
+
+func (r managedRenderer) highlight(book models.BookGroup, highlight models.Highlight) (string, error) {
+	block, err := r.bookRenderer.highlight(book, highlight)
+	if err != nil {
+		return "", err
+	}
+	return r.wrap(highlight, block), nil
+}
+
+func (r managedRenderer) wrap(highlight models.Highlight, block string) string {
+	begin := managedBegin + highlight.ID()
+	if start, _, err := parser.ParseLocation(highlight.Location); err == nil {
+		begin += " loc=" + strconv.Itoa(start)
+	}
+	begin += " sum=" + blockChecksum(block)
+
+	return r.comment.line(begin) + block + r.comment.line(managedEnd+highlight.ID())
+}
+
+// existing recognises marked highlights, and unmarked ones the way the
+// wrapped renderer does.
+func (r managedRenderer) existing(content string, book models.BookGroup) func(models.Highlight) bool {
+	blocks := r.blocks(content)
+	inner := r.bookRenderer.existing(content, book)
+
+	return func(highlight models.Highlight) bool {
+		_, ok := blocks[highlight.ID()]
+		return ok || inner(highlight)
+	}
+}
+
+func (r managedRenderer) locate(content string, highlight models.Highlight) (int, bool) {
+	if block, ok := r.blocks(content)[highlight.ID()]; ok {
+		return block.start, true
+	}
+	if locator, ok := r.bookRenderer.(locatingRenderer); ok {
+		return locator.locate(content, highlight)
+	}
+	return 0, false
+}
+
+// anchors places new highlights among every marked one in the note, not just
+// those in the current export.
+func (r managedRenderer) anchors(content string) []anchor {
+	var anchors []anchor
+	for _, block := range r.blocks(content) {
+		if block.location >= 0 {
+			anchors = append(anchors, anchor{offset: block.start, location: block.location, page: -1})
+		}
+	}
+	return anchors
+}
+
+func (r managedRenderer) blocks(content string) map[string]managedBlock {
+	blocks := make(map[string]managedBlock)
+
+	for _, match := range r.beginRe.FindAllStringSubmatchIndex(content, -1) {
+		block := managedBlock{
+			id:           content[match[2]:match[3]],
+			location:     -1,
+			sum:          content[match[6]:match[7]],
+			start:        match[0],
+			contentStart: min(match[1]+1, len(content)),
+			end:          -1,
+		}
+		if match[4] >= 0 {
+			block.location, _ = strconv.Atoi(content[match[4]:match[5]])
+		}
+
+		endLine := r.comment.line(managedEnd + block.id)
+		if i := strings.Index(content[block.contentStart:], endLine); i >= 0 {
+			block.contentEnd = block.contentStart + i
+			block.end = block.contentEnd + len(endLine)
+		}
+
+		blocks[block.id] = block
+	}
+
+	return blocks
+}
+
+// refresh re-renders the marked blocks of highlights whose source changed
+// since they were written, e.g. a note added on the Kindle later. Blocks the
+// user edited are reported as conflicts instead of being overwritten, and
+// highlights whose block was deleted stay deleted.
+func (r managedRenderer) refresh(content string, book models.BookGroup, highlights []models.Highlight) (string, int, []models.Conflict, error) {
+	type replacement struct {
+		start, end int
+		text       string
+	}
+
+	blocks := r.blocks(content)
+	var replacements []replacement
+	var conflicts []models.Conflict
+
+	for _, highlight := range highlights {
+		block, ok := blocks[highlight.ID()]
+		if !ok {
+			continue
+		}
+		if block.end < 0 {
+			conflicts = append(conflicts, models.Conflict{HighlightID: highlight.ID(), Reason: conflictNoEndMark})
+			continue
+		}
+
+		fresh, err := r.bookRenderer.highlight(book, highlight)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		if blockChecksum(fresh) == block.sum {
+			continue
+		}
+
+		current := content[block.contentStart:block.contentEnd]
+		if blockChecksum(current) != block.sum && current != fresh {
+			conflicts = append(conflicts, models.Conflict{HighlightID: highlight.ID(), Reason: conflictEdited})
+			continue
+		}
+
+		replacements = append(replacements, replacement{start: block.start, end: block.end, text: r.wrap(highlight, fresh)})
+	}
+
+	// Splice from the end so earlier offsets stay valid
+	sort.Slice(replacements, func(i, j int) bool {
+		return replacements[i].start > replacements[j].start
+	})
+	for _, rep := range replacements {
+		content = content[:rep.start] + rep.text + content[rep.end:]
+	}
+
+	return content, len(replacements), conflicts, nil
+}
+
+// remove cuts the marked blocks of the highlight IDs out of content, for
+// highlights deleted in the source. Blocks the user edited are kept and
+// reported as conflicts, and IDs without a block are skipped.
+func (r managedRenderer) remove(content string, ids []string) (string, []string, []models.Conflict) {
+	blocks := r.blocks(content)
+	var removing []managedBlock
+	var removed []string
+	var conflicts []models.Conflict
+
+	for _, id := range ids {
+		block, ok := blocks[id]
+		if !ok {
+			continue
+		}
+		if block.end < 0 {
+			conflicts = append(conflicts, models.Conflict{HighlightID: id, Reason: conflictNoEndMark})
+			continue
+		}
+		if blockChecksum(content[block.contentStart:block.contentEnd]) != block.sum {
+			conflicts = append(conflicts, models.Conflict{HighlightID: id, Reason: conflictKept})
+			continue
+		}
+
+		removing = append(removing, block)
+		removed = append(removed, id)
+	}
+
+	// Cut from the end so earlier offsets stay valid
+	sort.Slice(removing, func(i, j int) bool {
+		return removing[i].start > removing[j].start
+	})
+	for _, block := range removing {
+		content = content[:block.start] + content[block.end:]
+	}
+
+	return content, removed, conflicts
+}
+
+// blockChecksum fingerprints a block as written, to tell user edits apart.
+func blockChecksum(block string) string {
+	sum := sha1.Sum([]byte(block))
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,203 @@
+package exporter
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/parser"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+// Merge modes decide where new highlights go in an existing note.
+const (
+	MergeAppend  = "append"  // After everything already in the note
+	MergeOrdered = "ordered" // In reading order among the highlights already there
+)
+
+// anchoringRenderer is implemented by renderers that can find every highlight
+// in a note on their own, with its location or page, including those written
+// by earlier exports.
+type anchoringRenderer interface {
+	anchors(content string) []anchor
+}
+
+// locatingRenderer is implemented by renderers that can find a highlight's
+// block in a note even after the text around it was edited.
+type locatingRenderer interface {
+	// locate returns the offset where the highlight's block starts.
+	locate(content string, highlight models.Highlight) (int, bool)
+}
+
+func (s *Service) validateMerge() error {
+	switch s.config.Merge {
+	case "", MergeAppend, MergeOrdered:
+		return nil
+	}
+	return fmt.Errorf("unknown merge mode %q: expected %s or %s", s.config.Merge, MergeAppend, MergeOrdered)
+}
+
+// anchor is a highlight already in the note, by where its block starts and
+// where it is in the book.
+type anchor struct {
+	offset   int
+	location int // -1 when the note does not say
+	page     int // -1 when the note does not say
+}
+
+// follows reports whether the anchor is later in the book than a highlight
+// at location and page, comparing pages only when either has no location.
+func (a anchor) follows(location, page int) bool {
+	if a.location >= 0 && location >= 0 {
+		return a.location > location
+	}
+	if a.page >= 0 && page >= 0 {
+		return a.page > page
+	}
+	return false
+}
+
+// position returns the start location and page of a highlight, -1 for
+// either when it has none.
+func position(highlight models.Highlight) (int, int) {
+	location, _, err := parser.ParseLocation(highlight.Location)
+	if err != nil {
+		location = -1
+	}
+	page, err := strconv.Atoi(highlight.Page)
+	if err != nil {
+		page = -1
+	}
+	return location, page
+}
+
+type insertion struct {
+	offset int
+	text   string
+}
+
+// mergeByLocation inserts each new highlight right before the first block in
+// body with a later location, or page when the note has no locations, or at
+// the end when there is none. Anything
+// between existing blocks, such as the user's own notes, stays attached to
+// the block above it.
+func mergeByLocation(renderer bookRenderer, book models.BookGroup, body string, existing, highlights []models.Highlight) (string, error) {
+	var anchors []anchor
+	if anchoring, ok := renderer.(anchoringRenderer); ok {
+		anchors = anchoring.anchors(body)
+	}
+	for _, highlight := range existing {
+		location, page := position(highlight)
+		if location < 0 && page < 0 {
+			continue
+		}
+		if offset, ok := locateHighlight(renderer, body, book, highlight); ok {
+			anchors = append(anchors, anchor{offset: offset, location: location, page: page})
+		}
+	}
+
+	// Highlights without a location keep their order, after the rest
+	ordered := make([]models.Highlight, len(highlights))
+	copy(ordered, highlights)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, _, errA := parser.ParseLocation(ordered[i].Location)
+		b, _, errB := parser.ParseLocation(ordered[j].Location)
+		if errA != nil || errB != nil {
+			return errA == nil && errB != nil
+		}
+		return a < b
+	})
+
+	insertions := make([]insertion, 0, len(ordered))
+	for _, highlight := range ordered {
+		rendered, err := renderer.highlight(book, highlight)
+		if err != nil {
+			return "", err
+		}
+
+		offset := len(body)
+		location, page := position(highlight)
+		for _, a := range anchors {
+			if a.follows(location, page) && a.offset < offset {
+				offset = a.offset
+			}
+		}
+		insertions = append(insertions, insertion{offset: offset, text: rendered})
+	}
+	sort.SliceStable(insertions, func(i, j int) bool {
+		return insertions[i].offset < insertions[j].offset
+	})
+
+	var merged strings.Builder
+	last := 0
+	for _, ins := range insertions {
+		merged.WriteString(body[last:ins.offset])
+		if ins.offset == len(body) && merged.Len() > 0 && !strings.HasSuffix(merged.String(), "\n") {
+			merged.WriteString("\n")
+		}
+		merged.WriteString(ins.text)
+		last = ins.offset
+	}
+	merged.WriteString(body[last:])
+
+	return merged.String(), nil
+}
+
+// locateHighlight finds where a highlight's block starts in content, falling
+// back to its unedited rendering for renderers that cannot locate blocks.
+func locateHighlight(renderer bookRenderer, content string, book models.BookGroup, highlight models.Highlight) (int, bool) {
+	if locator, ok := renderer.(locatingRenderer); ok {
+		if offset, ok := locator.locate(content, highlight); ok {
+			return offset, true
+		}
+	}
+
+	rendered, err := renderer.highlight(book, highlight)
+	if err != nil || rendered == "" {
+		return 0, false
+	}
+
+	offset := strings.Index(content, rendered)
+	return offset, offset >= 0
+}
+
+// eachLine calls fn with the offset and text of every line in content.
+func eachLine(content string, fn func(offset int, line string)) {
+	for offset := 0; offset < len(content); {
+		end := strings.IndexByte(content[offset:], '\n')
+		if end < 0 {
+			end = len(content) - offset
+		}
+		fn(offset, content[offset:offset+end])
+		offset += end + 1
+	}
+}
+
+// lineStart returns the offset of the start of the line holding offset.
+func lineStart(content string, offset int) int {
+	return strings.LastIndex(content[:offset], "\n") + 1
+}
+
+// findLine returns the start of the first line beginning with prefix.
+func findLine(content, prefix string) (int, bool) {
+	if strings.HasPrefix(content, prefix) {
+		return 0, true
+	}
+	if i := strings.Index(content, "\n"+prefix); i >= 0 {
+		return i + 1, true
+	}
+	return 0, false
+}
+
+// blockStart returns the start of the last line before offset that begins
+// with prefix, e.g. the headline owning an Org property.
+func blockStart(content string, offset int, prefix string) (int, bool) {
+	if i := strings.LastIndex(content[:offset], "\n"+prefix); i >= 0 {
+		return i + 1, true
+	}
+	if strings.HasPrefix(content, prefix) {
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,193 @@
+package exporter
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/parser"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const (
+	blockIDPrefix     = "loc"
+	blockIDHashLength = 6
+	indexHeader       = "# Books\n\n"
+)
+
+// blockIDRe matches a line ending with a block ID that carries a location.
+var blockIDRe = regexp.MustCompile(`^(.*)\^` + blockIDPrefix + `-(\d+)-[0-9a-f]+$`)
+
+// obsidianRenderer writes notes with a ^block-id on every highlight, so
+// individual quotes can be embedded and linked, and wikilinks to authors.
+type obsidianRenderer struct {
+	markdown markdownRenderer
+	callouts bool
+}
+
+func (r obsidianRenderer) extension() string {
+	return markdownExtension
+}
+
+func (r obsidianRenderer) header(book models.BookGroup) (string, error) {
+	return fmt.Sprintf(headerFormat, book.Title) + "Author: " + authorLinks(book.Author) + "\n\n", nil
+}
+
+func (r obsidianRenderer) highlight(book models.BookGroup, highlight models.Highlight) (string, error) {
+	id := blockID(highlight)
+
+	if !r.callouts {
+		rendered := highlightPrefix + highlight.Text + fmt.Sprintf(pageFormat, highlight.Page) + " ^" + id + "\n"
+		if highlight.Note != "" {
+			rendered += "  - Note: " + strings.ReplaceAll(highlight.Note, "\n", " ") + "\n"
+		}
+		return rendered, nil
+	}
+
+	var rendered strings.Builder
+	fmt.Fprintf(&rendered, "> [!quote] Page %s, location %s\n", highlight.Page, highlight.Location)
+	writeQuoted(&rendered, "> ", highlight.Text)
+	if highlight.Note != "" {
+		rendered.WriteString(">\n> > [!note]\n")
+		writeQuoted(&rendered, "> > ", highlight.Note)
+	}
+	// Block IDs for multi-line blocks go on their own line after the block
+	rendered.WriteString("\n^" + id + "\n\n")
+
+	return rendered.String(), nil
+}
+
+func (r obsidianRenderer) footer(book models.BookGroup) (string, error) {
+	return "", nil
+}
+
+// existing matches highlights by their block ID, so the surrounding line
+// can be reformatted freely, and also recognises the default layout.
+func (r obsidianRenderer) existing(content string, book models.BookGroup) func(models.Highlight) bool {
+	inDefaultLayout := r.markdown.existing(content, book)
+
+	return func(highlight models.Highlight) bool {
+		return inDefaultLayout(highlight) || strings.Contains(content, "^"+blockID(highlight)+"\n")
+	}
+}
+
+// locate finds the highlight by its block ID: the line ending with it, or
+// the callout above it when the ID is on its own line.
+func (r obsidianRenderer) locate(content string, highlight models.Highlight) (int, bool) {
+	i := strings.Index(content, "^"+blockID(highlight)+"\n")
+	if i < 0 {
+		return r.markdown.locate(content, highlight)
+	}
+
+	start := lineStart(content, i)
+	if start == i {
+		return blockStart(content, i, "> [!quote]")
+	}
+	return start, true
+}
+
+// anchors places new highlights by the location in every block ID, and by
+// page for lines in the default layout.
+func (r obsidianRenderer) anchors(content string) []anchor {
+	anchors := r.markdown.anchors(content)
+	eachLine(content, func(offset int, line string) {
+		matches := blockIDRe.FindStringSubmatch(line)
+		if matches == nil {
+			return
+		}
+		location, _ := strconv.Atoi(matches[2])
+
+		// Block IDs on their own line belong to the callout above them
+		start := offset
+		if matches[1] == "" {
+			var ok bool
+			if start, ok = blockStart(content, offset, "> [!quote]"); !ok {
+				return
+			}
+		}
+		anchors = append(anchors, anchor{offset: start, location: location, page: -1})
+	})
+	return anchors
+}
+
+// blockID derives a stable Obsidian block ID from the highlight's location,
+// with a short hash so highlights sharing a location stay distinct.
+func blockID(highlight models.Highlight) string {
+	hash := highlight.ID()[:blockIDHashLength]
+
+	start, _, err := parser.ParseLocation(highlight.Location)
+	if err != nil {
+		return blockIDPrefix + "-" + hash
+	}
+
+	return fmt.Sprintf("%s-%d-%s", blockIDPrefix, start, hash)
+}
+
+func authorLinks(author string) string {
+	authors := splitAuthors(author)
+	links := make([]string, 0, len(authors))
+	for _, name := range authors {
+		links = append(links, "[["+name+"]]")
+	}
+	return strings.Join(links, ", ")
+}
+
+func writeQuoted(b *strings.Builder, prefix, text string) {
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(prefix + line + "\n")
+	}
+}
+
+// updateObsidianIndex adds a line linking each book exported without error
+// to the index note, leaving existing lines and anything the user wrote in
+// place.
+func (s *Service) updateObsidianIndex(bookHighlights map[string][]models.Highlight, results []models.ExportResult) error {
+	filename := filepath.Join(s.config.HomeDir, s.config.NotesDirectory, s.config.Obsidian.Index)
+	if filepath.Ext(filename) == "" {
+		filename += markdownExtension
+	}
+
+	content, exists, err := s.loadExistingFile(filename)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		content = indexHeader
+	}
+
+	updated := content
+	for _, result := range results {
+		if result.Err != nil {
+			continue // Its note may never have been written
+		}
+
+		book := newBookGroup(result.BookTitle, bookHighlights[result.BookTitle])
+		note := result.FilePath
+		noteName := s.linkName(note)
+		link := s.noteLink(s.displayBook(book), note)
+
+		if strings.Contains(updated, "[["+noteName+"]]") || strings.Contains(updated, "[["+noteName+"|") {
+			continue
+		}
+
+		if updated != "" && !strings.HasSuffix(updated, "\n") {
+			updated += "\n"
+		}
+		if authors := authorLinks(book.Author); authors != "" {
+			link += " by " + authors
+		}
+		updated += highlightPrefix + link + "\n"
+	}
+
+	if updated == content && exists {
+		return nil
+	}
+
+	if err := s.ensureDirectoryExists(filename); err != nil {
+		return err
+	}
+
+	return s.writeFile(filename, updated)
+}
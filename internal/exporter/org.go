@@ -0,0 +1,120 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/parser"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const (
+	orgExtension       = ".org"
+	orgIDProperty      = ":ID:"
+	orgLocation        = ":LOCATION:"
+	orgHeadlineLength  = 60
+	orgTimestampFormat = "[2006-01-02 Mon 15:04]"
+)
+
+// orgRenderer writes one Org-mode file per book. Each highlight is a headline
+// whose property drawer holds its ID, page, location and date, with the text
+// in a quote block.
+type orgRenderer struct{}
+
+func (r orgRenderer) extension() string {
+	return orgExtension
+}
+
+func (r orgRenderer) header(book models.BookGroup) (string, error) {
+	return fmt.Sprintf("#+TITLE: %s\n#+AUTHOR: %s\n\n", book.Title, book.Author), nil
+}
+
+func (r orgRenderer) highlight(book models.BookGroup, highlight models.Highlight) (string, error) {
+	var entry strings.Builder
+
+	fmt.Fprintf(&entry, "* %s\n", orgHeadline(highlight.Text))
+	entry.WriteString(":PROPERTIES:\n")
+	fmt.Fprintf(&entry, "%-10s %s\n", orgIDProperty, highlight.ID())
+	if highlight.Page != "" {
+		fmt.Fprintf(&entry, "%-10s %s\n", ":PAGE:", highlight.Page)
+	}
+	if highlight.Location != "" {
+		fmt.Fprintf(&entry, "%-10s %s\n", orgLocation, highlight.Location)
+	}
+	if added, err := parser.ParseDate(highlight.Date); err == nil {
+		fmt.Fprintf(&entry, "%-10s %s\n", ":ADDED:", added.Format(orgTimestampFormat))
+	}
+	entry.WriteString(":END:\n")
+
+	entry.WriteString("#+BEGIN_QUOTE\n" + highlight.Text + "\n#+END_QUOTE\n")
+	if highlight.Note != "" {
+		entry.WriteString(highlight.Note + "\n")
+	}
+	entry.WriteString("\n")
+
+	return entry.String(), nil
+}
+
+func (r orgRenderer) footer(book models.BookGroup) (string, error) {
+	return "", nil
+}
+
+// existing matches highlights by the ID property of their drawer.
+func (r orgRenderer) existing(content string, book models.BookGroup) func(models.Highlight) bool {
+	ids := make(map[string]bool)
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, orgIDProperty) {
+			ids[strings.TrimSpace(strings.TrimPrefix(line, orgIDProperty))] = true
+		}
+	}
+
+	return func(highlight models.Highlight) bool {
+		return ids[highlight.ID()]
+	}
+}
+
+// locate finds the headline whose drawer holds the highlight's ID.
+func (r orgRenderer) locate(content string, highlight models.Highlight) (int, bool) {
+	for offset := 0; offset < len(content); {
+		i := strings.Index(content[offset:], highlight.ID())
+		if i < 0 {
+			break
+		}
+		i += offset
+
+		start := lineStart(content, i)
+		if strings.HasPrefix(strings.TrimSpace(content[start:i]), orgIDProperty) {
+			return blockStart(content, start, "* ")
+		}
+		offset = i + len(highlight.ID())
+	}
+	return 0, false
+}
+
+// anchors places new highlights by the LOCATION property of every headline.
+func (r orgRenderer) anchors(content string) []anchor {
+	var anchors []anchor
+	eachLine(content, func(offset int, line string) {
+		property, ok := strings.CutPrefix(strings.TrimSpace(line), orgLocation)
+		if !ok {
+			return
+		}
+		location, _, err := parser.ParseLocation(strings.TrimSpace(property))
+		if err != nil {
+			return
+		}
+		if start, ok := blockStart(content, offset, "* "); ok {
+			anchors = append(anchors, anchor{offset: start, location: location, page: -1})
+		}
+	})
+	return anchors
+}
+
+// orgHeadline shortens text to a single headline line.
+func orgHeadline(text string) string {
+	return shortLine(text, orgHeadlineLength)
+}
@@ -0,0 +1,185 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/parser"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const (
+	logseqPagesDirectory = "pages"
+	logseqIDProperty     = "highlight-id"
+	roamExtension        = ".json"
+)
+
+// logseqRenderer writes one Logseq page per book, each highlight a block with
+// its location, page and journal date as child properties.
+type logseqRenderer struct {
+	source string
+}
+
+func (r logseqRenderer) extension() string {
+	return markdownExtension
+}
+
+func (r logseqRenderer) subdirectory() string {
+	return logseqPagesDirectory
+}
+
+func (r logseqRenderer) header(book models.BookGroup) (string, error) {
+	var header strings.Builder
+	fmt.Fprintf(&header, "title:: %s\n", book.Title)
+	fmt.Fprintf(&header, "author:: %s\n", authorLinks(book.Author))
+	if r.source != "" {
+		fmt.Fprintf(&header, "source:: %s\n", r.source)
+	}
+	header.WriteString("type:: book\n\n")
+	return header.String(), nil
+}
+
+func (r logseqRenderer) highlight(book models.BookGroup, highlight models.Highlight) (string, error) {
+	var block strings.Builder
+
+	block.WriteString(highlightPrefix + highlight.Text + "\n")
+	for _, property := range outlineProperties(highlight, journalDate) {
+		block.WriteString("  " + property + "\n")
+	}
+	fmt.Fprintf(&block, "  %s:: %s\n", logseqIDProperty, highlight.ID())
+
+	if highlight.Note != "" {
+		block.WriteString("  - " + strings.ReplaceAll(highlight.Note, "\n", " ") + "\n")
+	}
+
+	return block.String(), nil
+}
+
+func (r logseqRenderer) footer(book models.BookGroup) (string, error) {
+	return "", nil
+}
+
+// existing matches highlights by their highlight-id property.
+func (r logseqRenderer) existing(content string, book models.BookGroup) func(models.Highlight) bool {
+	return func(highlight models.Highlight) bool {
+		return strings.Contains(content, logseqIDProperty+":: "+highlight.ID()+"\n")
+	}
+}
+
+// locate finds the block holding the highlight's highlight-id property.
+func (r logseqRenderer) locate(content string, highlight models.Highlight) (int, bool) {
+	i := strings.Index(content, logseqIDProperty+":: "+highlight.ID()+"\n")
+	if i < 0 {
+		return 0, false
+	}
+	return blockStart(content, i, highlightPrefix)
+}
+
+// anchors places new highlights by the location property of every block.
+func (r logseqRenderer) anchors(content string) []anchor {
+	var anchors []anchor
+	eachLine(content, func(offset int, line string) {
+		property, ok := strings.CutPrefix(strings.TrimSpace(line), "location::")
+		if !ok {
+			return
+		}
+		location, _, err := parser.ParseLocation(strings.TrimSpace(property))
+		if err != nil {
+			return
+		}
+		if start, ok := blockStart(content, offset, highlightPrefix); ok {
+			anchors = append(anchors, anchor{offset: start, location: location, page: -1})
+		}
+	})
+	return anchors
+}
+
+type roamBlock struct {
+	String   string      `json:"string"`
+	Children []roamBlock `json:"children,omitempty"`
+}
+
+type roamPage struct {
+	Title    string      `json:"title"`
+	Children []roamBlock `json:"children"`
+}
+
+// WriteRoamJSON writes highlights as a Roam Research import file with one
+// page per book.
+func WriteRoamJSON(w io.Writer, highlights []models.Highlight) error {
+	var pages []roamPage
+
+	for _, book := range groupBooks(highlights) {
+		page := roamPage{
+			Title:    book.Title,
+			Children: []roamBlock{{String: "Author:: " + authorLinks(book.Author)}},
+		}
+
+		for _, highlight := range book.Highlights {
+			block := roamBlock{String: highlight.Text}
+			for _, property := range outlineProperties(highlight, roamDate) {
+				block.Children = append(block.Children, roamBlock{String: property})
+			}
+			if highlight.Note != "" {
+				block.Children = append(block.Children, roamBlock{String: highlight.Note})
+			}
+			page.Children = append(page.Children, block)
+		}
+
+		pages = append(pages, page)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(pages)
+}
+
+// outlineProperties lists the "key:: value" properties shared by Logseq and
+// Roam blocks, linking the highlight date to the journal page titled by
+// journalTitle.
+func outlineProperties(highlight models.Highlight, journalTitle func(time.Time) string) []string {
+	var properties []string
+
+	if highlight.Location != "" {
+		properties = append(properties, "location:: "+highlight.Location)
+	}
+	if highlight.Page != "" {
+		properties = append(properties, "page:: "+highlight.Page)
+	}
+	if added, err := parser.ParseDate(highlight.Date); err == nil {
+		properties = append(properties, "added:: [["+journalTitle(added)+"]]")
+	}
+
+	return properties
+}
+
+// journalDate formats t like Logseq journal page titles, e.g.
+// "Sep 6th, 2024".
+func journalDate(t time.Time) string {
+	return fmt.Sprintf("%s %d%s, %d", t.Format("Jan"), t.Day(), ordinalSuffix(t.Day()), t.Year())
+}
+
+// roamDate formats t like Roam daily page titles, which spell the month out,
+// e.g. "September 6th, 2024".
+func roamDate(t time.Time) string {
+	return fmt.Sprintf("%s %d%s, %d", t.Format("January"), t.Day(), ordinalSuffix(t.Day()), t.Year())
+}
+
+func ordinalSuffix(day int) string {
+	if day >= 11 && day <= 13 {
+		return "th"
+	}
+
+	switch day % 10 {
+	case 1:
+		return "st"
+	case 2:
+		return "nd"
+	case 3:
+		return "rd"
+	}
+	return "th"
+}
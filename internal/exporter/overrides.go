@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/config"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+// bookOverrides returns what config.toml overrides for the book, matched by
+// its title as on the Kindle or its ID.
+func (s *Service) bookOverrides(book models.BookGroup) config.Book {
+	return s.config.Book(book.Title, book.ID())
+}
+
+// withoutIgnored drops the books config.toml says never to export.
+func (s *Service) withoutIgnored(bookHighlights map[string][]models.Highlight) map[string][]models.Highlight {
+	kept := make(map[string][]models.Highlight, len(bookHighlights))
+	for title, highlights := range bookHighlights {
+		if !s.bookOverrides(newBookGroup(title, highlights)).Ignore {
+			kept[title] = highlights
+		}
+	}
+	return kept
+}
+
+// displayBook returns the book as its notes show it, under its display
+// title. It keeps the book's ID.
+func (s *Service) displayBook(book models.BookGroup) models.BookGroup {
+	if title := s.config.DisplayTitle(book.Title, book.ID()); title != book.Title {
+		book.SourceTitle, book.Title = book.Title, title
+	}
+	return book
+}
+
+// bookTags returns the tags written to the book's front matter.
+func (s *Service) bookTags(book models.BookGroup) []string {
+	extra := s.bookOverrides(book).Tags
+	if len(extra) == 0 {
+		return s.config.FrontMatter.Tags
+	}
+
+	tags := append([]string{}, s.config.FrontMatter.Tags...)
+	for _, tag := range extra {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// sanitizeFolder makes each element of a folder path safe, dropping those
+// that would leave the notes directory.
+func (s *Service) sanitizeFolder(folder string) string {
+	var elements []string
+	for _, element := range strings.FieldsFunc(folder, func(r rune) bool { return r == '/' || r == '\\' }) {
+		element = s.sanitizeFilename(element)
+		if element == "" || element == "." || element == ".." {
+			continue
+		}
+		elements = append(elements, element)
+	}
+	return strings.Join(elements, "/")
+}
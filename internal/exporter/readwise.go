@@ -0,0 +1,60 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/parser"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const (
+	readwiseExtension  = ".csv"
+	readwiseDateFormat = "2006-01-02 15:04:05"
+)
+
+var readwiseHeader = []string{"Highlight", "Title", "Author", "URL", "Note", "Location", "Date"}
+
+// WriteReadwiseCSV writes highlights in the format accepted by Readwise's
+// CSV upload.
+func WriteReadwiseCSV(w io.Writer, highlights []models.Highlight) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(readwiseHeader); err != nil {
+		return err
+	}
+
+	for _, highlight := range highlights {
+		record := []string{
+			highlight.Text,
+			highlight.Title,
+			highlight.Author,
+			"",
+			highlight.Note,
+			readwiseLocation(highlight.Location),
+			readwiseDate(highlight.Date),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// readwiseLocation keeps the start of a Kindle location range, since
+// Readwise expects a single number.
+func readwiseLocation(location string) string {
+	start, _, _ := strings.Cut(location, "-")
+	return start
+}
+
+func readwiseDate(date string) string {
+	t, err := parser.ParseDate(date)
+	if err != nil {
+		return date
+	}
+	return t.Format(readwiseDateFormat)
+}
@@ -0,0 +1,137 @@
+package exporter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+// footerMarker separates the highlights from a rendered footer, so the footer
+// can be replaced when new highlights are appended.
+const footerMarker = "<!-- kindle-highlights:footer -->\n"
+
+// bookRenderer lays out the note file written for each book.
+type bookRenderer interface {
+	extension() string
+	header(book models.BookGroup) (string, error)
+	highlight(book models.BookGroup, highlight models.Highlight) (string, error)
+	footer(book models.BookGroup) (string, error)
+	// existing reports whether a highlight is already in content.
+	existing(content string, book models.BookGroup) func(models.Highlight) bool
+}
+
+// subdirectoryRenderer is implemented by renderers whose notes live in a
+// folder below the notes directory.
+type subdirectoryRenderer interface {
+	subdirectory() string
+}
+
+func (s *Service) bookRenderer() (bookRenderer, error) {
+	if s.renderer != nil {
+		return s.renderer, nil
+	}
+
+	var renderer bookRenderer
+	switch format := s.format(); format {
+	case FormatMarkdown:
+		renderer = markdownRenderer{s}
+		if s.config.Templates.Highlight != "" || s.config.Templates.Header != "" || s.config.Templates.Footer != "" {
+			templates, err := s.loadTemplates()
+			if err != nil {
+				return nil, fmt.Errorf("loading templates: %w", err)
+			}
+			renderer = templates
+		}
+	case FormatObsidian:
+		renderer = obsidianRenderer{markdown: markdownRenderer{s}, callouts: s.config.Obsidian.Callouts}
+	case FormatLogseq:
+		renderer = logseqRenderer{source: s.config.FrontMatter.Source}
+	case FormatOrg:
+		renderer = orgRenderer{}
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+
+	if s.config.ManagedRegions {
+		switch s.format() {
+		case FormatLogseq:
+			return nil, fmt.Errorf("managed regions are not supported by the %s format", FormatLogseq)
+		case FormatOrg:
+			renderer = newManagedRenderer(renderer, orgComment)
+		default:
+			renderer = newManagedRenderer(renderer, htmlComment)
+		}
+	}
+
+	s.renderer = renderer
+	return renderer, nil
+}
+
+// splitFooter separates content at the footer marker.
+func splitFooter(content string) (string, string) {
+	if i := strings.LastIndex(content, footerMarker); i >= 0 {
+		return content[:i], content[i+len(footerMarker):]
+	}
+	return content, ""
+}
+
+// shortLine collapses text onto one line of at most length runes, cutting
+// it short with an ellipsis.
+func shortLine(text string, length int) string {
+	text = strings.Join(strings.Fields(text), " ")
+
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+
+	return strings.TrimSpace(string(runes[:length-3])) + "..."
+}
+
+// markdownRenderer writes the default "- text (Page: n)" layout.
+type markdownRenderer struct {
+	s *Service
+}
+
+func (r markdownRenderer) extension() string {
+	return markdownExtension
+}
+
+func (r markdownRenderer) header(book models.BookGroup) (string, error) {
+	return fmt.Sprintf(headerFormat, book.Title), nil
+}
+
+func (r markdownRenderer) highlight(book models.BookGroup, highlight models.Highlight) (string, error) {
+	return highlightPrefix + highlight.Text + fmt.Sprintf(pageFormat, highlight.Page) + "\n", nil
+}
+
+func (r markdownRenderer) footer(book models.BookGroup) (string, error) {
+	return "", nil
+}
+
+func (r markdownRenderer) existing(content string, book models.BookGroup) func(models.Highlight) bool {
+	keys := r.s.extractExistingHighlights(content)
+	return func(highlight models.Highlight) bool {
+		return keys[r.s.createHighlightKey(highlight)]
+	}
+}
+
+// locate finds the highlight's line by its text, so the page can be edited.
+func (r markdownRenderer) locate(content string, highlight models.Highlight) (int, bool) {
+	return findLine(content, highlightPrefix+highlight.Text)
+}
+
+// anchors places new highlights by the page of every highlight line, as the
+// layout has no locations.
+func (r markdownRenderer) anchors(content string) []anchor {
+	var anchors []anchor
+	eachLine(content, func(offset int, line string) {
+		if matches := r.s.highlightRe.FindStringSubmatch(strings.TrimSpace(line)); len(matches) == 3 {
+			page, _ := strconv.Atoi(matches[2])
+			anchors = append(anchors, anchor{offset: offset, location: -1, page: page})
+		}
+	})
+	return anchors
+}
@@ -0,0 +1,316 @@
+package exporter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/schema"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const (
+	defaultSiteDirectory = "kindle-highlights-site"
+	siteBooksDirectory   = "books"
+	legacySearchIndex    = "search-index.json" // Now inlined in index.html
+	htmlExtension        = ".html"
+)
+
+type siteBook struct {
+	models.BookGroup
+	ID   string
+	Path string
+}
+
+type siteAuthor struct {
+	Name  string
+	Books []siteBook
+}
+
+type sitePage struct {
+	Title   string
+	Root    string // Relative path back to the site root
+	Books   []siteBook
+	Book    siteBook
+	Authors []siteAuthor
+	Index   []searchEntry // Search index of the index page
+}
+
+// searchEntry is one record of the client-side search index.
+type searchEntry struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Text   string `json:"text"`
+	Note   string `json:"note,omitempty"`
+	Path   string `json:"path"`
+}
+
+var siteTemplates = template.Must(template.New("site").Funcs(template.FuncMap{
+	"highlightID": func(highlight models.Highlight) string { return highlight.ID() },
+}).Parse(siteTemplate))
+
+// exportSite writes a self-contained static site: an index of books with
+// search, one page per book and an author index. The whole site is
+// regenerated on every export, removing the pages of books no longer in
+// it.
+func (s *Service) exportSite(bookHighlights map[string][]models.Highlight) ([]models.ExportResult, error) {
+	if s.config.ExportFile == StdoutPath {
+		return []models.ExportResult{}, fmt.Errorf("%s writes a directory and cannot be streamed to stdout", FormatHTML)
+	}
+
+	root := filepath.Join(s.config.HomeDir, s.config.NotesDirectory, defaultSiteDirectory)
+	if s.config.ExportFile != "" {
+		root = s.buildSingleFilePath("")
+	}
+
+	books, results := buildSiteBooks(bookHighlights)
+	for i, book := range books {
+		results[i].FilePath = filepath.Join(root, book.Path)
+	}
+
+	pages := map[string]sitePage{
+		"index.html":   {Title: "Highlights", Root: ".", Books: books, Index: buildSearchIndex(books)},
+		"authors.html": {Title: "Authors", Root: ".", Authors: groupSiteAuthors(books)},
+	}
+	for _, book := range books {
+		pages[book.Path] = sitePage{Title: book.Title, Root: "..", Book: book}
+	}
+
+	for path, page := range pages {
+		var content bytes.Buffer
+		if err := siteTemplates.ExecuteTemplate(&content, templateName(path), page); err != nil {
+			return []models.ExportResult{}, fmt.Errorf("rendering %s: %w", path, err)
+		}
+
+		if err := s.writeSiteFile(filepath.Join(root, path), content.Bytes()); err != nil {
+			return []models.ExportResult{}, err
+		}
+	}
+
+	if err := s.removeStalePages(root, pages); err != nil {
+		return []models.ExportResult{}, err
+	}
+
+	return results, nil
+}
+
+// removeStalePages deletes the book pages left by earlier exports, such as
+// those of renamed books, and the search index older versions wrote beside
+// the pages.
+func (s *Service) removeStalePages(root string, pages map[string]sitePage) error {
+	entries, err := s.fs.ReadDir(filepath.Join(root, siteBooksDirectory))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("listing book pages: %w", err)
+	}
+
+	stale := []string{filepath.Join(root, legacySearchIndex)}
+	for _, entry := range entries {
+		path := siteBooksDirectory + "/" + entry.Name()
+		if _, ok := pages[path]; !ok && !entry.IsDir() && filepath.Ext(path) == htmlExtension {
+			stale = append(stale, filepath.Join(root, filepath.FromSlash(path)))
+		}
+	}
+
+	for _, path := range stale {
+		if _, err := s.fs.Stat(path); err != nil {
+			continue
+		}
+		if err := s.removeFile(path); err != nil {
+			return fmt.Errorf("removing %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) writeSiteFile(filename string, content []byte) error {
+	if err := s.ensureDirectoryExists(filename); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+	if err := s.writeFile(filename, string(content)); err != nil {
+		return fmt.Errorf("writing file: %w", err)
+	}
+	return nil
+}
+
+func templateName(path string) string {
+	switch path {
+	case "index.html", "authors.html":
+		return path
+	}
+	return "book.html"
+}
+
+// buildSiteBooks gives every book a page path from its title, falling back
+// to its ID when two titles share a slug.
+func buildSiteBooks(bookHighlights map[string][]models.Highlight) ([]siteBook, []models.ExportResult) {
+	var books []siteBook
+	var results []models.ExportResult
+	usedPaths := make(map[string]bool)
+
+	for _, title := range sortedTitles(bookHighlights) {
+		highlights := bookHighlights[title]
+		if len(highlights) == 0 {
+			continue
+		}
+
+		book := siteBook{BookGroup: newBookGroup(title, highlights)}
+		book.ID = book.BookGroup.ID()
+
+		name := slugify(title)
+		if name == "" || usedPaths[name] {
+			name = book.ID
+		}
+		usedPaths[name] = true
+		book.Path = siteBooksDirectory + "/" + name + htmlExtension
+
+		books = append(books, book)
+		results = append(results, models.ExportResult{
+			BookTitle:  title,
+			NewCount:   len(highlights),
+			TotalCount: len(highlights),
+			WrittenIDs: highlightIDs(highlights),
+		})
+	}
+
+	return books, results
+}
+
+func groupSiteAuthors(books []siteBook) []siteAuthor {
+	byName := make(map[string]*siteAuthor)
+	var names []string
+
+	for _, book := range books {
+		for _, name := range splitAuthors(book.Author) {
+			author, ok := byName[name]
+			if !ok {
+				author = &siteAuthor{Name: name}
+				byName[name] = author
+				names = append(names, name)
+			}
+			author.Books = append(author.Books, book)
+		}
+	}
+
+	sort.Strings(names)
+	authors := make([]siteAuthor, 0, len(names))
+	for _, name := range names {
+		authors = append(authors, *byName[name])
+	}
+
+	return authors
+}
+
+func buildSearchIndex(books []siteBook) []searchEntry {
+	entries := []searchEntry{} // Never null in the page
+
+	for _, book := range books {
+		for _, highlight := range book.Highlights {
+			converted := schema.FromHighlight(highlight)
+			entries = append(entries, searchEntry{
+				ID:     converted.ID,
+				Title:  converted.Title,
+				Author: converted.Author,
+				Text:   converted.Text,
+				Note:   converted.Note,
+				Path:   book.Path,
+			})
+		}
+	}
+
+	return entries
+}
+
+const siteTemplate = `
+{{define "head"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: Georgia, serif; max-width: 46rem; margin: 2rem auto; padding: 0 1rem; color: #222; line-height: 1.5; }
+nav a { margin-right: 1rem; }
+a { color: #7D56F4; }
+blockquote { margin: 1.5rem 0; padding-left: 1rem; border-left: 3px solid #7D56F4; }
+.meta, .count { color: #777; font-size: 0.85rem; }
+.note { font-style: italic; }
+input[type=search] { width: 100%; padding: 0.5rem; font-size: 1rem; }
+</style>
+</head>
+<body>
+<nav><a href="{{.Root}}/index.html">Books</a><a href="{{.Root}}/authors.html">Authors</a></nav>
+{{end}}
+
+{{define "foot"}}</body>
+</html>
+{{end}}
+
+{{define "index.html"}}{{template "head" .}}
+<h1>Books</h1>
+<input type="search" id="search" placeholder="Search highlights" autocomplete="off">
+<ol id="results"></ol>
+<ul id="books">
+{{range .Books}}<li><a href="{{.Path}}">{{.Title}}</a> <span class="meta">{{.Author}}</span> <span class="count">{{len .Highlights}} highlights</span></li>
+{{end}}</ul>
+<script>
+(function () {
+  var input = document.getElementById("search");
+  var results = document.getElementById("results");
+  var books = document.getElementById("books");
+  // Inlined, as pages opened from file:// cannot fetch
+  var index = {{.Index}};
+
+  function render(query) {
+    results.innerHTML = "";
+    books.hidden = query !== "";
+    if (query === "") { return; }
+
+    var needle = query.toLowerCase();
+    var matches = index.filter(function (entry) {
+      return [entry.text, entry.note || "", entry.title, entry.author].some(function (field) {
+        return field.toLowerCase().indexOf(needle) !== -1;
+      });
+    }).slice(0, 100);
+
+    matches.forEach(function (entry) {
+      var item = document.createElement("li");
+      var link = document.createElement("a");
+      link.href = entry.path + "#h-" + entry.id;
+      link.textContent = entry.text;
+      var meta = document.createElement("div");
+      meta.className = "meta";
+      meta.textContent = entry.title + " — " + entry.author;
+      item.appendChild(link);
+      item.appendChild(meta);
+      results.appendChild(item);
+    });
+  }
+
+  input.addEventListener("input", function () { render(input.value.trim()); });
+})();
+</script>
+{{template "foot" .}}{{end}}
+
+{{define "authors.html"}}{{template "head" .}}
+<h1>Authors</h1>
+{{range .Authors}}<h2>{{.Name}}</h2>
+<ul>
+{{range .Books}}<li><a href="{{.Path}}">{{.Title}}</a> <span class="count">{{len .Highlights}} highlights</span></li>
+{{end}}</ul>
+{{end}}{{template "foot" .}}{{end}}
+
+{{define "book.html"}}{{template "head" .}}
+<h1>{{.Book.Title}}</h1>
+<p class="meta">{{.Book.Author}} · {{len .Book.Highlights}} highlights</p>
+{{range .Book.Highlights}}<blockquote id="h-{{highlightID .}}">
+<p>{{.Text}}</p>
+{{if .Note}}<p class="note">{{.Note}}</p>
+{{end}}<p class="meta">{{if .Page}}Page {{.Page}} · {{end}}Location {{.Location}}{{if .Date}} · {{.Date}}{{end}}</p>
+</blockquote>
+{{end}}{{template "foot" .}}{{end}}
+`
@@ -0,0 +1,288 @@
+package exporter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+// Error policies decide what happens to the other books when one fails.
+const (
+	OnErrorStop     = "stop"     // Start no more books after a failure, keeping the books already exported
+	OnErrorContinue = "continue" // Export the remaining books and report every failure
+	OnErrorRollback = "rollback" // Write nothing unless every book succeeds
+)
+
+func (s *Service) validateOnError() error {
+	switch s.config.OnError {
+	case "", OnErrorStop, OnErrorContinue, OnErrorRollback:
+		return nil
+	}
+	return fmt.Errorf("unknown error policy %q: expected %s, %s or %s", s.config.OnError, OnErrorStop, OnErrorContinue, OnErrorRollback)
+}
+
+// exportStaged exports every book in memory first and writes the notes only
+// once all of them succeeded, so a failing book leaves the notes directory
+// as it was.
+func (s *Service) exportStaged(bookHighlights map[string][]models.Highlight) ([]models.ExportResult, error) {
+	staged := newStagedFileSystem(s.fs)
+
+	cfg := *s.config
+	cfg.OnError = OnErrorContinue // Report every failing book, not just the first
+	cfg.Backups = 0               // Backups are taken when the writes are committed
+
+	stage := NewWithFileSystem(&cfg, staged)
+	stage.SetStdout(s.stdout)
+	stage.source = s.source
+
+	results, err := stage.ExportHighlights(bookHighlights)
+	if err != nil {
+		clearWritten(results)
+		return results, fmt.Errorf("nothing was written: %w", err)
+	}
+
+	if err := s.startBackupRun(); err != nil {
+		return []models.ExportResult{}, err
+	}
+
+	if failed, err := staged.commit(s); err != nil {
+		s.discardBackupRun()
+		for i := range results {
+			if results[i].FilePath == failed {
+				results[i].Err = fmt.Errorf("exporting highlights for %q: %w", results[i].BookTitle, err)
+			}
+		}
+		clearWritten(results)
+		return results, fmt.Errorf("nothing was written: %w", err)
+	}
+
+	if err := s.finishBackupRun(); err != nil {
+		return results, fmt.Errorf("recording backups: %w", err)
+	}
+	return results, nil
+}
+
+// clearWritten resets what the results report as written, for exports that
+// were rolled back.
+func clearWritten(results []models.ExportResult) {
+	for i := range results {
+		results[i].NewCount = 0
+		results[i].UpdatedCount = 0
+		results[i].WrittenIDs = nil
+	}
+}
+
+// stagedFileSystem keeps writes and removals in memory, reading through to
+// the wrapped file system for files they did not touch. It is safe for
+// concurrent use while the books export.
+type stagedFileSystem struct {
+	base    FileSystem
+	mu      sync.Mutex
+	pending map[string][]byte
+	removed map[string]bool
+}
+
+func newStagedFileSystem(base FileSystem) *stagedFileSystem {
+	return &stagedFileSystem{base: base, pending: make(map[string][]byte), removed: make(map[string]bool)}
+}
+
+// staged reports whether name was written or removed, with the content of
+// a write.
+func (fs *stagedFileSystem) staged(name string) (data []byte, removed, ok bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.removed[name] {
+		return nil, true, true
+	}
+	data, ok = fs.pending[name]
+	return data, false, ok
+}
+
+func (fs *stagedFileSystem) Stat(name string) (os.FileInfo, error) {
+	if data, removed, ok := fs.staged(name); ok {
+		if removed {
+			return nil, os.ErrNotExist
+		}
+		return pendingFileInfo{name: filepath.Base(name), size: int64(len(data))}, nil
+	}
+	return fs.base.Stat(name)
+}
+
+func (fs *stagedFileSystem) ReadFile(filename string) ([]byte, error) {
+	if data, removed, ok := fs.staged(filename); ok {
+		if removed {
+			return nil, os.ErrNotExist
+		}
+		return bytes.Clone(data), nil
+	}
+	return fs.base.ReadFile(filename)
+}
+
+func (fs *stagedFileSystem) WriteFile(filename string, data []byte, perm os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.removed, filename)
+	fs.pending[filename] = bytes.Clone(data)
+	return nil
+}
+
+func (fs *stagedFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return nil
+}
+
+// ReadDir lists the files of the wrapped directory as the pending writes
+// and removals would leave it.
+func (fs *stagedFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	base, err := fs.base.ReadDir(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	entries := make(map[string]os.DirEntry)
+	for _, entry := range base {
+		if !fs.removed[filepath.Join(name, entry.Name())] {
+			entries[entry.Name()] = entry
+		}
+	}
+	for path, data := range fs.pending {
+		if filepath.Dir(path) == name {
+			entries[filepath.Base(path)] = iofs.FileInfoToDirEntry(pendingFileInfo{name: filepath.Base(path), size: int64(len(data))})
+		}
+	}
+	if err != nil && len(entries) == 0 {
+		return nil, err
+	}
+
+	names := slices.Sorted(maps.Keys(entries))
+	listed := make([]os.DirEntry, 0, len(names))
+	for _, entryName := range names {
+		listed = append(listed, entries[entryName])
+	}
+	return listed, nil
+}
+
+func (fs *stagedFileSystem) Remove(name string) error {
+	if _, err := fs.Stat(name); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	delete(fs.pending, name)
+	if _, err := fs.base.Stat(name); err == nil {
+		fs.removed[name] = true
+	}
+	return nil
+}
+
+func (fs *stagedFileSystem) RemoveAll(path string) error {
+	return nil
+}
+
+// commit applies the pending changes through the service, which backs each
+// file up first. Files the writes left unchanged are skipped. When a change
+// fails, the files already changed are put back as they were and the
+// failing path is returned with the error.
+func (fs *stagedFileSystem) commit(s *Service) (string, error) {
+	changes := fs.changes()
+	for i, change := range changes {
+		var err error
+		switch {
+		case change.Deleted:
+			if err = s.removeFile(change.Path); err != nil {
+				err = fmt.Errorf("removing file: %w", err)
+			}
+		default:
+			if err = s.ensureDirectoryExists(change.Path); err != nil {
+				err = fmt.Errorf("creating directory: %w", err)
+			} else if err = s.writeFile(change.Path, change.After); err != nil {
+				err = fmt.Errorf("writing file: %w", err)
+			}
+		}
+
+		if err != nil {
+			if revertErr := fs.revert(changes[:i]); revertErr != nil {
+				err = errors.Join(err, revertErr)
+			}
+			return change.Path, err
+		}
+	}
+
+	return "", nil
+}
+
+// revert puts committed files back as they were before the commit.
+func (fs *stagedFileSystem) revert(committed []FileChange) error {
+	var errs []error
+	for _, change := range committed {
+		if change.Created {
+			if err := fs.base.Remove(change.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, fmt.Errorf("removing %s: %w", change.Path, err))
+			}
+			continue
+		}
+		if err := fs.base.WriteFile(change.Path, []byte(change.Before), filePermissions); err != nil {
+			errs = append(errs, fmt.Errorf("reverting %s: %w", change.Path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// changes compares every pending write and removal with the file on disk,
+// skipping writes that leave a file as it was.
+func (fs *stagedFileSystem) changes() []FileChange {
+	paths := make([]string, 0, len(fs.pending)+len(fs.removed))
+	for path := range fs.pending {
+		paths = append(paths, path)
+	}
+	for path := range fs.removed {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	changes := make([]FileChange, 0, len(paths))
+	for _, path := range paths {
+		change := FileChange{Path: path, After: string(fs.pending[path]), Deleted: fs.removed[path]}
+
+		if before, err := fs.base.ReadFile(path); err == nil {
+			change.Before = string(before)
+		} else {
+			change.Created = true
+		}
+
+		if change.Deleted && change.Created {
+			continue // Gone already
+		}
+		if !change.Created && !change.Deleted && change.Before == change.After {
+			continue
+		}
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+type pendingFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi pendingFileInfo) Name() string       { return fi.name }
+func (fi pendingFileInfo) Size() int64        { return fi.size }
+func (fi pendingFileInfo) Mode() os.FileMode  { return filePermissions }
+func (fi pendingFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi pendingFileInfo) IsDir() bool        { return false }
+func (fi pendingFileInfo) Sys() any           { return nil }
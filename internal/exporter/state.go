@@ -0,0 +1,173 @@
+package exporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+const (
+	stateDirectory = ".kindle-highlights"
+	stateFilename  = "state.json"
+	stateVersion   = 1
+)
+
+// syncState is the manifest of which highlights were written to which file,
+// kept at .kindle-highlights/state.json in the notes directory. It decides
+// what is a duplicate, so notes can be reformatted without highlights being
+// exported again. Its methods are safe to call from concurrent exports.
+type syncState struct {
+	Version int                    `json:"version"`
+	Files   map[string]*syncedFile `json:"files"` // Keyed by slash-separated path relative to the notes directory
+
+	mu    sync.Mutex
+	dirty bool
+}
+
+type syncedFile struct {
+	BookID     string   `json:"book_id,omitempty"`
+	Title      string   `json:"title,omitempty"`
+	Highlights []string `json:"highlights"` // Highlight IDs, in the order they were written
+}
+
+func newSyncState() *syncState {
+	return &syncState{Version: stateVersion, Files: make(map[string]*syncedFile)}
+}
+
+// has reports whether the highlight ID was written to the file.
+func (f *syncedFile) has(id string) bool {
+	return slices.Contains(f.Highlights, id)
+}
+
+// file returns a copy of the manifest entry for path, if it is tracked.
+func (st *syncState) file(path string) (*syncedFile, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	file, ok := st.Files[path]
+	if !ok {
+		return nil, false
+	}
+	copied := *file
+	copied.Highlights = slices.Clone(file.Highlights)
+	return &copied, true
+}
+
+// forget drops a file from the manifest, e.g. once the user deleted it.
+func (st *syncState) forget(path string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, ok := st.Files[path]; ok {
+		delete(st.Files, path)
+		st.dirty = true
+	}
+}
+
+// record notes that the highlight IDs are in the file.
+func (st *syncState) record(path, bookID, title string, ids []string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	file, ok := st.Files[path]
+	if !ok {
+		file = &syncedFile{BookID: bookID, Title: title, Highlights: []string{}}
+		st.Files[path] = file
+		st.dirty = true
+	}
+
+	for _, id := range ids {
+		if !file.has(id) {
+			file.Highlights = append(file.Highlights, id)
+			st.dirty = true
+		}
+	}
+}
+
+// drop notes that the highlight IDs are no longer in the file.
+func (st *syncState) drop(path string, ids []string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	file, ok := st.Files[path]
+	if !ok {
+		return
+	}
+
+	for _, id := range ids {
+		if i := slices.Index(file.Highlights, id); i >= 0 {
+			file.Highlights = slices.Delete(file.Highlights, i, i+1)
+			st.dirty = true
+		}
+	}
+}
+
+func (s *Service) notesRoot() string {
+	return filepath.Join(s.config.HomeDir, s.config.NotesDirectory)
+}
+
+func (s *Service) statePath() string {
+	return filepath.Join(s.notesRoot(), stateDirectory, stateFilename)
+}
+
+// stateKey is the manifest key of filename: its path relative to the notes
+// directory, so the vault can be moved.
+func (s *Service) stateKey(filename string) string {
+	rel, err := filepath.Rel(s.notesRoot(), filename)
+	if err != nil {
+		return filepath.ToSlash(filename)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// loadState reads the manifest, starting an empty one when the notes
+// directory has none yet.
+func (s *Service) loadState() (*syncState, error) {
+	data, err := s.fs.ReadFile(s.statePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return newSyncState(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading sync state: %w", err)
+	}
+
+	state := newSyncState()
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parsing sync state %s: %w", s.statePath(), err)
+	}
+	if state.Version > stateVersion {
+		return nil, fmt.Errorf("sync state %s has version %d, newer than this release supports", s.statePath(), state.Version)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]*syncedFile)
+	}
+
+	return state, nil
+}
+
+// saveState writes the manifest back when the export changed it.
+func (s *Service) saveState(state *syncState) error {
+	if !state.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding sync state: %w", err)
+	}
+
+	filename := s.statePath()
+	if err := s.ensureDirectoryExists(filename); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+	if err := s.writeFile(filename, string(data)+"\n"); err != nil {
+		return fmt.Errorf("writing sync state: %w", err)
+	}
+
+	state.dirty = false
+	return nil
+}
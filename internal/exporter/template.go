@@ -0,0 +1,207 @@
+package exporter
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/parser"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const (
+	defaultHeaderTemplate    = "# {{.Title}}\n\n"
+	defaultHighlightTemplate = "- {{.Text}} (Page: {{.Page}})\n"
+)
+
+// highlightData is passed to the highlight template, exposing every
+// Highlight field alongside the book it belongs to.
+type highlightData struct {
+	models.Highlight
+	Book models.BookGroup
+}
+
+// templateRenderer lays out book notes from the user's text/template files.
+type templateRenderer struct {
+	markdown          markdownRenderer
+	ext               string
+	headerTemplate    *template.Template
+	highlightTemplate *template.Template
+	footerTemplate    *template.Template
+}
+
+var templateFuncs = template.FuncMap{
+	"date": formatDate,
+	"wrap": wrapText,
+	"slug": slugify,
+}
+
+func (s *Service) loadTemplates() (*templateRenderer, error) {
+	cfg := s.config.Templates
+
+	header, err := s.parseTemplate("header", cfg.Header, defaultHeaderTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	highlight, err := s.parseTemplate("highlight", cfg.Highlight, defaultHighlightTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	footer, err := s.parseTemplate("footer", cfg.Footer, "")
+	if err != nil {
+		return nil, err
+	}
+
+	extension := cfg.Extension
+	if extension == "" {
+		extension = markdownExtension
+	}
+	if !strings.HasPrefix(extension, ".") {
+		extension = "." + extension
+	}
+
+	return &templateRenderer{
+		markdown:          markdownRenderer{s},
+		ext:               extension,
+		headerTemplate:    header,
+		highlightTemplate: highlight,
+		footerTemplate:    footer,
+	}, nil
+}
+
+func (s *Service) parseTemplate(name, path, fallback string) (*template.Template, error) {
+	text := fallback
+	if path != "" {
+		data, err := s.fs.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s template: %w", name, err)
+		}
+		text = string(data)
+	}
+
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s template: %w", name, err)
+	}
+
+	return tmpl, nil
+}
+
+func (r *templateRenderer) extension() string {
+	return r.ext
+}
+
+func (r *templateRenderer) header(book models.BookGroup) (string, error) {
+	return executeTemplate(r.headerTemplate, book)
+}
+
+func (r *templateRenderer) highlight(book models.BookGroup, highlight models.Highlight) (string, error) {
+	return executeTemplate(r.highlightTemplate, highlightData{Highlight: highlight, Book: book})
+}
+
+func (r *templateRenderer) footer(book models.BookGroup) (string, error) {
+	return executeTemplate(r.footerTemplate, book)
+}
+
+// existing treats a highlight as exported when its rendered block is already
+// in the note, ignoring differences in whitespace, or when the note still
+// holds it in the default layout.
+func (r *templateRenderer) existing(content string, book models.BookGroup) func(models.Highlight) bool {
+	inDefaultLayout := r.markdown.existing(content, book)
+	normalizedContent := normalizeSpace(content)
+
+	return func(highlight models.Highlight) bool {
+		if inDefaultLayout(highlight) {
+			return true
+		}
+
+		rendered, err := r.highlight(book, highlight)
+		if err != nil {
+			return false
+		}
+
+		block := normalizeSpace(rendered)
+		return block != "" && strings.Contains(normalizedContent, block)
+	}
+}
+
+// executeTemplate renders tmpl, making sure the result ends in a newline so
+// consecutive blocks do not run together.
+func executeTemplate(tmpl *template.Template, data any) (string, error) {
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", err
+	}
+
+	result := rendered.String()
+	if result != "" && !strings.HasSuffix(result, "\n") {
+		result += "\n"
+	}
+
+	return result, nil
+}
+
+func normalizeSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// formatDate reformats a Kindle "Added on" date with a Go time layout,
+// returning the original value when it cannot be parsed.
+func formatDate(layout, date string) string {
+	t, err := parser.ParseDate(date)
+	if err != nil {
+		return date
+	}
+	return t.Format(layout)
+}
+
+// wrapText breaks text into lines of at most width characters at word
+// boundaries.
+func wrapText(width int, text string) string {
+	words := strings.Fields(text)
+	if len(words) == 0 || width <= 0 {
+		return text
+	}
+
+	var wrapped strings.Builder
+	lineLength := 0
+	for i, word := range words {
+		wordLength := len([]rune(word))
+		if i > 0 {
+			if lineLength+1+wordLength > width {
+				wrapped.WriteString("\n")
+				lineLength = 0
+			} else {
+				wrapped.WriteString(" ")
+				lineLength++
+			}
+		}
+		wrapped.WriteString(word)
+		lineLength += wordLength
+	}
+
+	return wrapped.String()
+}
+
+// slugify lowercases text and joins its letters and digits with hyphens.
+func slugify(text string) string {
+	var slug strings.Builder
+	pendingHyphen := false
+
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if pendingHyphen && slug.Len() > 0 {
+				slug.WriteRune('-')
+			}
+			slug.WriteRune(r)
+			pendingHyphen = false
+			continue
+		}
+		pendingHyphen = true
+	}
+
+	return slug.String()
+}
@@ -0,0 +1,161 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/parser"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+// zettelSummaryLength caps the text shown beside each link in a book note.
+const zettelSummaryLength = 60
+
+// zettelFrontMatter is the metadata at the top of each highlight note.
+type zettelFrontMatter struct {
+	ID       string   `yaml:"id"`
+	Book     string   `yaml:"book"`
+	Authors  []string `yaml:"authors,omitempty"`
+	Page     string   `yaml:"page,omitempty"`
+	Location string   `yaml:"location,omitempty"`
+	Added    string   `yaml:"added,omitempty"`
+	Source   string   `yaml:"source,omitempty"`
+	Tags     []string `yaml:"tags,omitempty"`
+}
+
+// exportZettelkasten writes one note per highlight, named after its ID, and
+// a book note linking to them. Highlight notes that already exist are never
+// rewritten, so they can be edited and linked freely.
+func (s *Service) exportZettelkasten(bookHighlights map[string][]models.Highlight) ([]models.ExportResult, error) {
+	s.claimPaths(bookHighlights, markdownExtension, "")
+	return s.exportEach(bookHighlights, s.exportZettelBook)
+}
+
+func (s *Service) exportZettelBook(title string, highlights []models.Highlight) (models.ExportResult, error) {
+	directory := filepath.Join(s.config.HomeDir, s.config.NotesDirectory, s.config.Zettelkasten.Directory)
+	book := newBookGroup(title, highlights)
+	bookNote := s.buildFilePath(book, markdownExtension, "")
+	bookID, tags := book.ID(), s.bookTags(book)
+	book = s.displayBook(book)
+	bookLink := s.noteLink(book, bookNote)
+
+	result := models.ExportResult{
+		BookTitle:  title,
+		FilePath:   bookNote,
+		TotalCount: len(highlights),
+	}
+
+	var written []models.Highlight
+	for _, highlight := range highlights {
+		filename := filepath.Join(directory, highlight.ID()+markdownExtension)
+		key := s.stateKey(filename)
+		if _, err := s.fs.Stat(filename); err == nil {
+			s.state.record(key, bookID, title, []string{highlight.ID()})
+			result.SkippedIDs = append(result.SkippedIDs, highlight.ID())
+			continue
+		}
+		s.state.forget(key)
+
+		content, err := s.renderZettel(bookLink, tags, highlight)
+		if err != nil {
+			return result, fmt.Errorf("rendering highlight %s: %w", highlight.ID(), err)
+		}
+		if err := s.ensureDirectoryExists(filename); err != nil {
+			return result, fmt.Errorf("creating directory: %w", err)
+		}
+		if err := s.writeFile(filename, content); err != nil {
+			return result, fmt.Errorf("writing file: %w", err)
+		}
+		s.state.record(key, bookID, title, []string{highlight.ID()})
+		written = append(written, highlight)
+		result.WrittenIDs = append(result.WrittenIDs, highlight.ID())
+	}
+
+	if err := s.updateZettelBookNote(bookNote, book, written); err != nil {
+		return result, fmt.Errorf("updating book note: %w", err)
+	}
+
+	result.NewCount = len(written)
+	result.SkippedCount = len(highlights) - len(written)
+	return result, nil
+}
+
+func (s *Service) renderZettel(bookLink string, tags []string, highlight models.Highlight) (string, error) {
+	metadata := zettelFrontMatter{
+		ID:       highlight.ID(),
+		Book:     bookLink,
+		Authors:  splitAuthors(highlight.Author),
+		Page:     highlight.Page,
+		Location: highlight.Location,
+		Source:   s.config.FrontMatter.Source,
+		Tags:     tags,
+	}
+	if added, err := parser.ParseDate(highlight.Date); err == nil {
+		metadata.Added = added.Format(frontMatterDateFormat)
+	}
+
+	var encoded bytes.Buffer
+	encoder := yaml.NewEncoder(&encoded)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(metadata); err != nil {
+		return "", fmt.Errorf("encoding front matter: %w", err)
+	}
+
+	var content strings.Builder
+	content.WriteString(frontMatterDelimiter + encoded.String() + frontMatterDelimiter + "\n")
+	writeQuoted(&content, "> ", highlight.Text)
+	if highlight.Note != "" {
+		content.WriteString("\n" + highlight.Note + "\n")
+	}
+
+	return content.String(), nil
+}
+
+// updateZettelBookNote lists each newly written highlight note in the book's
+// note, creating it when missing and leaving existing lines in place.
+func (s *Service) updateZettelBookNote(filename string, book models.BookGroup, written []models.Highlight) error {
+	content, exists, err := s.loadExistingFile(filename)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		content = fmt.Sprintf(headerFormat, book.Title) + "Author: " + authorLinks(book.Author) + "\n\n"
+	}
+
+	updated := content
+	for _, highlight := range written {
+		link := "[[" + highlight.ID() + "]]"
+		if strings.Contains(updated, link) {
+			continue
+		}
+
+		if updated != "" && !strings.HasSuffix(updated, "\n") {
+			updated += "\n"
+		}
+		updated += highlightPrefix + link + " " + shortLine(highlight.Text, zettelSummaryLength) + "\n"
+	}
+
+	if updated == content && exists {
+		return nil
+	}
+
+	if err := s.ensureDirectoryExists(filename); err != nil {
+		return err
+	}
+
+	return s.writeFile(filename, updated)
+}
+
+// noteLink is a wikilink to the book's note at filename, showing the
+// original title when the note is named differently.
+func (s *Service) noteLink(book models.BookGroup, filename string) string {
+	noteName := s.linkName(filename)
+	if noteName != book.Title {
+		return "[[" + noteName + "|" + book.Title + "]]"
+	}
+	return "[[" + noteName + "]]"
+}
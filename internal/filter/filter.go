@@ -0,0 +1,68 @@
+package filter
+
+import (
+	"strings"
+	"time"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/parser"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+type Options struct {
+	Books []string  // Book titles to keep, matched case-insensitively
+	Since time.Time // Keep highlights added on or after this time
+	Query string    // Case-insensitive text to find in the highlight, note, title or author
+}
+
+// Apply returns the highlights matching every option that is set. Highlights
+// without a parseable date are dropped when Since is set.
+func Apply(highlights []models.Highlight, opts Options) []models.Highlight {
+	var filtered []models.Highlight
+
+	for _, highlight := range highlights {
+		if !matchesBook(highlight, opts.Books) {
+			continue
+		}
+
+		if opts.Query != "" && !matchesQuery(highlight, opts.Query) {
+			continue
+		}
+
+		if !opts.Since.IsZero() {
+			added, err := parser.ParseDate(highlight.Date)
+			if err != nil || added.Before(opts.Since) {
+				continue
+			}
+		}
+
+		filtered = append(filtered, highlight)
+	}
+
+	return filtered
+}
+
+func matchesBook(highlight models.Highlight, books []string) bool {
+	if len(books) == 0 {
+		return true
+	}
+
+	for _, book := range books {
+		if strings.EqualFold(strings.TrimSpace(book), highlight.Title) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesQuery(highlight models.Highlight, query string) bool {
+	query = strings.ToLower(query)
+
+	for _, field := range []string{highlight.Text, highlight.Note, highlight.Title, highlight.Author} {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,72 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+func TestApply(t *testing.T) {
+	highlights := []models.Highlight{
+		{Title: "Sandworm", Text: "Old", Date: "Wednesday, 10 April 2024 22:27:53"},
+		{Title: "Sandworm", Text: "New", Date: "Monday, 6 May 2024 19:53:44"},
+		{Title: "Modern Software Engineering", Text: "Other", Date: "Sunday, 12 May 2024 09:50:49"},
+		{Title: "Sandworm", Text: "Undated", Note: "Check the SOURCE"},
+	}
+
+	tests := []struct {
+		name          string
+		opts          Options
+		expectedTexts []string
+	}{
+		{
+			name:          "no options keeps everything",
+			opts:          Options{},
+			expectedTexts: []string{"Old", "New", "Other", "Undated"},
+		},
+		{
+			name:          "book filter is case-insensitive",
+			opts:          Options{Books: []string{"sandworm"}},
+			expectedTexts: []string{"Old", "New", "Undated"},
+		},
+		{
+			name:          "since drops older and undated highlights",
+			opts:          Options{Since: time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC)},
+			expectedTexts: []string{"New", "Other"},
+		},
+		{
+			name:          "query matches text and notes case-insensitively",
+			opts:          Options{Query: "source"},
+			expectedTexts: []string{"Undated"},
+		},
+		{
+			name:          "query matches book title",
+			opts:          Options{Query: "modern"},
+			expectedTexts: []string{"Other"},
+		},
+		{
+			name: "book and since combined",
+			opts: Options{
+				Books: []string{"Sandworm"},
+				Since: time.Date(2024, time.May, 1, 0, 0, 0, 0, time.UTC),
+			},
+			expectedTexts: []string{"New"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Apply(highlights, tt.opts)
+
+			texts := make([]string, len(result))
+			for i, highlight := range result {
+				texts[i] = highlight.Text
+			}
+
+			assert.Equal(t, tt.expectedTexts, texts, "Should keep the matching highlights in order")
+		})
+	}
+}
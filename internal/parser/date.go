@@ -0,0 +1,30 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dateLayouts lists the timestamp formats seen in Kindle clippings and
+// Readwise exports, tried in order.
+var dateLayouts = []string{
+	"Monday, 2 January 2006 15:04:05",
+	"Monday, January 2, 2006 3:04:05 PM",
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// ParseDate parses the "Added on" value of a highlight.
+func ParseDate(date string) (time.Time, error) {
+	date = strings.TrimSpace(date)
+
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognised date %q", date)
+}
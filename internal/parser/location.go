@@ -0,0 +1,29 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseLocation parses a Kindle location such as "4933-4934" or "784" into
+// its start and end. A single location starts and ends at the same place.
+func ParseLocation(location string) (int, int, error) {
+	startText, endText, isRange := strings.Cut(strings.TrimSpace(location), "-")
+
+	start, err := strconv.Atoi(startText)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid location %q", location)
+	}
+
+	if !isRange {
+		return start, start, nil
+	}
+
+	end, err := strconv.Atoi(endText)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid location %q", location)
+	}
+
+	return start, end, nil
+}
@@ -3,6 +3,7 @@ package parser
 import (
 	"bufio"
 	"bytes"
+	"io"
 	"os"
 	"regexp"
 	"strings"
@@ -10,6 +11,8 @@ import (
 	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
 )
 
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
 func ParseClippings(filename string) ([]models.Highlight, error) {
 	file, err := os.Open(filename)
 
@@ -19,22 +22,33 @@ func ParseClippings(filename string) ([]models.Highlight, error) {
 
 	defer file.Close()
 
+	return ReadClippings(file)
+}
+
+// ReadClippings parses Kindle clippings from r.
+func ReadClippings(r io.Reader) ([]models.Highlight, error) {
 	var lines []string
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		lines = append(lines, scanner.Text())
 	}
 
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
 	return createHighlights(lines), nil
 }
 
 func createHighlights(lines []string) []models.Highlight {
 	var highlights []models.Highlight
 	var currentHighlight models.Highlight
+	var noteLocation string
+	isNote := false
 
 	title := regexp.MustCompile(`^(.*) \((.*)\)$`)
-	var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
 	metaData := regexp.MustCompile(`Your Highlight.*page ([0-9]+) .*location ([0-9-]+) \| Added on (.*)`)
+	noteMetaData := regexp.MustCompile(`Your Note.*location ([0-9]+) \| Added on`)
 
 	for i := range lines {
 		line := lines[i]
@@ -59,7 +73,25 @@ func createHighlights(lines []string) []models.Highlight {
 			continue
 		}
 
+		if noteMetaData.MatchString(line) {
+			noteLocation = noteMetaData.FindStringSubmatch(line)[1]
+			isNote = true
+			continue
+		}
+
+		// Bookmarks and other entries carry no text worth keeping
+		if strings.HasPrefix(line, "- Your ") {
+			continue
+		}
+
 		if len(line) > 0 && !strings.HasPrefix(line, "==========") {
+			if isNote {
+				attachNote(highlights, currentHighlight.Title, noteLocation, line)
+				currentHighlight = models.Highlight{}
+				isNote = false
+				continue
+			}
+
 			currentHighlight.Text = line
 			highlights = append(highlights, currentHighlight)
 			currentHighlight = models.Highlight{}
@@ -69,6 +101,38 @@ func createHighlights(lines []string) []models.Highlight {
 	return highlights
 }
 
+// attachNote adds a note to the highlight it was written against: the latest
+// highlight in the same book whose location range covers the note, falling
+// back to the latest highlight in that book.
+func attachNote(highlights []models.Highlight, title, location, note string) {
+	noteLocation, _, err := ParseLocation(location)
+	fallback := -1
+
+	for i := len(highlights) - 1; i >= 0; i-- {
+		if highlights[i].Title != title {
+			continue
+		}
+		if fallback < 0 {
+			fallback = i
+		}
+
+		start, end, locErr := ParseLocation(highlights[i].Location)
+		if err == nil && locErr == nil && noteLocation >= start && noteLocation <= end {
+			fallback = i
+			break
+		}
+	}
+
+	if fallback < 0 {
+		return
+	}
+
+	if highlights[fallback].Note != "" {
+		highlights[fallback].Note += "\n"
+	}
+	highlights[fallback].Note += note
+}
+
 func GroupHighlightsByBook(highlights []models.Highlight) []models.BookGroup {
 	bookMap := make(map[string][]models.Highlight)
 	authorMap := make(map[string]string)
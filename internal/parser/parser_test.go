@@ -5,6 +5,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -15,6 +16,8 @@ import (
 const CLIPPINGS_FILE_PATH = "../../testData/Test Clippings.txt"
 const STRANGE_CLIPPINGS_FILE_PATH = "../../testData/Strange Title Clippings.txt"
 const FORMATTED_MARKDOWN_FILE_PATH = "../../testData/SandwormFormatted.md"
+const READWISE_FILE_PATH = "../../testData/Readwise Export.csv"
+const NOTES_CLIPPINGS_FILE_PATH = "../../testData/Notes Clippings.txt"
 
 func TestParseClippings(t *testing.T) {
 	tests := []struct {
@@ -98,6 +101,122 @@ func TestParseClippings(t *testing.T) {
 	}
 }
 
+func TestParseClippingsWithNotes(t *testing.T) {
+	require.FileExists(t, NOTES_CLIPPINGS_FILE_PATH, "Test file should exist")
+
+	highlights, err := ParseClippings(NOTES_CLIPPINGS_FILE_PATH)
+	require.NoError(t, err, "Should parse clippings without error")
+	require.Len(t, highlights, 2, "Notes and bookmarks should not become highlights")
+
+	assert.Equal(t, "Compare with the Ukrainian grid attack", highlights[0].Note, "Note should attach to the highlight at its location")
+	assert.Empty(t, highlights[1].Note, "Other highlights should have no note")
+}
+
+func TestParseReadwise(t *testing.T) {
+	require.FileExists(t, READWISE_FILE_PATH, "Test file should exist")
+
+	highlights, err := Parse(READWISE_FILE_PATH)
+	require.NoError(t, err, "Should parse Readwise CSV without error")
+	require.Len(t, highlights, 2, "Should read every row")
+
+	assert.Equal(t, models.Highlight{
+		Title:    "Modern Software Engineering",
+		Author:   "Farley, David",
+		Location: "784",
+		Date:     "2024-05-12 09:50:49",
+		Text:     "If we add more people to speed up development, we will increase the communication overhead, coupling, and complexity, all of which will slow us down.",
+		Note:     "Brooks's law again",
+	}, highlights[1])
+}
+
+func TestReadReadwiseMissingHighlightColumn(t *testing.T) {
+	_, err := readReadwise(strings.NewReader("Title,Author\nSandworm,Greenberg\n"))
+	assert.Error(t, err, "Should reject files without a Highlight column")
+}
+
+func TestParseReader(t *testing.T) {
+	tests := []struct {
+		name          string
+		filePath      string
+		expectedCount int
+	}{
+		{name: "kindle clippings", filePath: CLIPPINGS_FILE_PATH, expectedCount: 3},
+		{name: "clippings with byte order mark", filePath: STRANGE_CLIPPINGS_FILE_PATH, expectedCount: 2},
+		{name: "readwise csv", filePath: READWISE_FILE_PATH, expectedCount: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := os.Open(tt.filePath)
+			require.NoError(t, err, "Test file should open")
+			defer file.Close()
+
+			highlights, err := ParseReader(file)
+			require.NoError(t, err, "Should parse without error")
+
+			expected, err := Parse(tt.filePath)
+			require.NoError(t, err, "Should parse file without error")
+
+			assert.Len(t, highlights, tt.expectedCount)
+			assert.Equal(t, expected, highlights, "Reader and file parsing should agree")
+		})
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	expected := time.Date(2024, time.May, 6, 19, 53, 44, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "kindle day-month format", input: "Monday, 6 May 2024 19:53:44"},
+		{name: "kindle month-day format", input: "Monday, May 6, 2024 7:53:44 PM"},
+		{name: "readwise format", input: "2024-05-06 19:53:44"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseDate(tt.input)
+			require.NoError(t, err, "Should parse date")
+			assert.True(t, expected.Equal(result), "Should parse to %v, got %v", expected, result)
+		})
+	}
+
+	_, err := ParseDate("yesterday")
+	assert.Error(t, err, "Should reject unknown formats")
+}
+
+func TestParseLocation(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedStart int
+		expectedEnd   int
+		shouldError   bool
+	}{
+		{name: "range", input: "4933-4934", expectedStart: 4933, expectedEnd: 4934},
+		{name: "single location", input: "784", expectedStart: 784, expectedEnd: 784},
+		{name: "empty", input: "", shouldError: true},
+		{name: "not a number", input: "abc-def", shouldError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, err := ParseLocation(tt.input)
+
+			if tt.shouldError {
+				assert.Error(t, err, "Should reject invalid locations")
+				return
+			}
+
+			require.NoError(t, err, "Should parse location")
+			assert.Equal(t, tt.expectedStart, start)
+			assert.Equal(t, tt.expectedEnd, end)
+		})
+	}
+}
+
 func TestGroupHighlightsByBook(t *testing.T) {
 	tests := []struct {
 		name               string
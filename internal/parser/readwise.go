@@ -0,0 +1,113 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const (
+	readwiseExtension = ".csv"
+	readwiseSniffSize = 64
+)
+
+// Parse reads highlights from filename, choosing the Readwise CSV reader for
+// .csv files and the Kindle clippings reader for everything else.
+func Parse(filename string) ([]models.Highlight, error) {
+	if strings.EqualFold(filepath.Ext(filename), readwiseExtension) {
+		return ParseReadwise(filename)
+	}
+
+	return ParseClippings(filename)
+}
+
+// ParseReader reads highlights from r, which has no file name to go by, so a
+// Readwise CSV is recognised by its header instead.
+func ParseReader(r io.Reader) ([]models.Highlight, error) {
+	buffered := bufio.NewReader(r)
+
+	head, _ := buffered.Peek(readwiseSniffSize)
+	head = bytes.TrimPrefix(head, utf8BOM)
+	if bytes.HasPrefix(bytes.ToLower(head), []byte("highlight,")) {
+		return readReadwise(buffered)
+	}
+
+	return ReadClippings(buffered)
+}
+
+func ParseReadwise(filename string) ([]models.Highlight, error) {
+	file, err := os.Open(filename)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return readReadwise(file)
+}
+
+func readReadwise(r io.Reader) ([]models.Highlight, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.TrimPrefix(name, "\ufeff")
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	if _, ok := columns["highlight"]; !ok {
+		return nil, fmt.Errorf("missing %q column", "Highlight")
+	}
+
+	var highlights []models.Highlight
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading record: %w", err)
+		}
+
+		field := func(name string) string {
+			i, ok := columns[name]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		text := field("highlight")
+		if text == "" {
+			continue
+		}
+
+		highlights = append(highlights, models.Highlight{
+			Title:    field("title"),
+			Author:   field("author"),
+			Location: field("location"),
+			Date:     field("date"),
+			Text:     text,
+			Note:     field("note"),
+		})
+	}
+
+	return highlights, nil
+}
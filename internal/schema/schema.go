@@ -0,0 +1,162 @@
+// Package schema defines the versioned JSON representation of parsed
+// highlights. The shapes are documented in docs/json-schema.md; any
+// incompatible change must bump Version.
+package schema
+
+import (
+	"time"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/parser"
+	"github.com/matthewrobinsdev/kindle-notes-parser/pkg/models"
+)
+
+const Version = 1
+
+// Document wraps JSON output with its schema version and the kind of data
+// it carries. NDJSON output writes the Data records one per line instead.
+type Document struct {
+	SchemaVersion int    `json:"schema_version"`
+	Kind          string `json:"kind"`
+	Data          any    `json:"data"`
+}
+
+type Location struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type Highlight struct {
+	ID          string     `json:"id"`
+	BookID      string     `json:"book_id"`
+	Title       string     `json:"title"`
+	Author      string     `json:"author"`
+	Text        string     `json:"text"`
+	Note        string     `json:"note,omitempty"`
+	Page        string     `json:"page,omitempty"`
+	Location    *Location  `json:"location,omitempty"`
+	LocationRaw string     `json:"location_raw,omitempty"`
+	AddedAt     *time.Time `json:"added_at,omitempty"`
+	AddedRaw    string     `json:"added_raw,omitempty"`
+}
+
+type Book struct {
+	ID             string      `json:"id"`
+	Title          string      `json:"title"`
+	Author         string      `json:"author"`
+	HighlightCount int         `json:"highlight_count"`
+	Highlights     []Highlight `json:"highlights,omitempty"`
+}
+
+type ExportResult struct {
+	Title        string     `json:"title"`
+	File         string     `json:"file,omitempty"`
+	NewCount     int        `json:"new"`
+	SkippedCount int        `json:"skipped"`
+	UpdatedCount int        `json:"updated"`
+	RemovedCount int        `json:"removed"`
+	TotalCount   int        `json:"total"`
+	WrittenIDs   []string   `json:"written_ids,omitempty"`
+	SkippedIDs   []string   `json:"skipped_ids,omitempty"`
+	RemovedIDs   []string   `json:"removed_ids,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	Conflicts    []Conflict `json:"conflicts,omitempty"`
+}
+
+type Conflict struct {
+	HighlightID string `json:"highlight_id"`
+	Reason      string `json:"reason"`
+}
+
+// FileChange is a file a dry-run export would create, modify or delete.
+type FileChange struct {
+	Path   string `json:"path"`
+	Action string `json:"action"` // "create", "modify" or "delete"
+	Diff   string `json:"diff"`
+}
+
+func FromHighlight(highlight models.Highlight) Highlight {
+	result := Highlight{
+		ID:          highlight.ID(),
+		BookID:      models.BookGroup{Title: highlight.Title, Author: highlight.Author}.ID(),
+		Title:       highlight.Title,
+		Author:      highlight.Author,
+		Text:        highlight.Text,
+		Note:        highlight.Note,
+		Page:        highlight.Page,
+		LocationRaw: highlight.Location,
+		AddedRaw:    highlight.Date,
+	}
+
+	if start, end, err := parser.ParseLocation(highlight.Location); err == nil {
+		result.Location = &Location{Start: start, End: end}
+	}
+
+	if added, err := parser.ParseDate(highlight.Date); err == nil {
+		result.AddedAt = &added
+	}
+
+	return result
+}
+
+func FromHighlights(highlights []models.Highlight) []Highlight {
+	result := make([]Highlight, 0, len(highlights))
+	for _, highlight := range highlights {
+		result = append(result, FromHighlight(highlight))
+	}
+	return result
+}
+
+// FromBook converts a book, including its highlights when withHighlights is
+// set.
+func FromBook(book models.BookGroup, withHighlights bool) Book {
+	result := Book{
+		ID:             book.ID(),
+		Title:          book.Title,
+		Author:         book.Author,
+		HighlightCount: len(book.Highlights),
+	}
+
+	if withHighlights {
+		result.Highlights = FromHighlights(book.Highlights)
+	}
+
+	return result
+}
+
+func FromExportResults(results []models.ExportResult) []ExportResult {
+	converted := make([]ExportResult, 0, len(results))
+	for _, result := range results {
+		exportResult := ExportResult{
+			Title:        result.BookTitle,
+			File:         result.FilePath,
+			NewCount:     result.NewCount,
+			SkippedCount: result.SkippedCount,
+			UpdatedCount: result.UpdatedCount,
+			RemovedCount: result.RemovedCount,
+			TotalCount:   result.TotalCount,
+			WrittenIDs:   result.WrittenIDs,
+			SkippedIDs:   result.SkippedIDs,
+			RemovedIDs:   result.RemovedIDs,
+		}
+		if result.Err != nil {
+			exportResult.Error = result.Err.Error()
+		}
+		for _, conflict := range result.Conflicts {
+			exportResult.Conflicts = append(exportResult.Conflicts, Conflict{HighlightID: conflict.HighlightID, Reason: conflict.Reason})
+		}
+		converted = append(converted, exportResult)
+	}
+	return converted
+}
+
+type Stats struct {
+	Books             int        `json:"books"`
+	Authors           int        `json:"authors"`
+	Highlights        int        `json:"highlights"`
+	Notes             int        `json:"notes"`
+	FirstAddedAt      *time.Time `json:"first_added_at,omitempty"`
+	LastAddedAt       *time.Time `json:"last_added_at,omitempty"`
+	TopBookID         string     `json:"top_book_id,omitempty"`
+	TopBookTitle      string     `json:"top_book_title,omitempty"`
+	TopBookHighlights int        `json:"top_book_highlights,omitempty"`
+}
@@ -11,32 +11,56 @@ import (
 
 type ExportCompleteMsg struct {
 	Results []models.ExportResult
+	Err     error
 }
 
 func (m *Model) exportSelected() tea.Cmd {
 	return func() tea.Msg {
-		bookHighlights := make(map[string][]models.Highlight)
-
-		for key, selected := range m.selected {
-			if selected {
-				// Parse key format "bookIndex:highlightIndex"
-				var bookIndex, highlightIndex int
-				fmt.Sscanf(key, "%d:%d", &bookIndex, &highlightIndex)
-
-				if bookIndex < len(m.books) && highlightIndex < len(m.books[bookIndex].Highlights) {
-					book := m.books[bookIndex]
-					highlight := book.Highlights[highlightIndex]
-					bookHighlights[book.Title] = append(bookHighlights[book.Title], highlight)
-				}
-			}
-		}
-
-		results, err := m.exporter.ExportHighlights(bookHighlights)
+		results, err := m.exporter.ExportHighlights(m.selectedHighlights())
 		if err != nil {
 			log.Printf("Error exporting highlights: %v", err)
-			return ExportCompleteMsg{Results: []models.ExportResult{}}
 		}
 
-		return ExportCompleteMsg{Results: results}
+		return ExportCompleteMsg{Results: results, Err: err}
 	}
 }
+
+// exportReportView lists what a failed export did for each book, so books
+// that were written are not mistaken for lost.
+func (m *Model) exportReportView() string {
+	s := titleStyle.Render("Export Failed") + "\n\n"
+	s += "Quit: any key\n\n"
+
+	for _, result := range m.report.Results {
+		if result.Err != nil {
+			s += removedStyle.Render(fmt.Sprintf("✗ %s: %v", result.BookTitle, result.Err)) + "\n"
+			continue
+		}
+		s += normalStyle.Render(fmt.Sprintf("✓ %s: %d new, %d skipped (%s)",
+			result.BookTitle, result.NewCount, result.SkippedCount, result.FilePath)) + "\n"
+	}
+
+	s += "\n" + removedStyle.Render(m.report.Err.Error()) + "\n"
+	return s
+}
+
+// selectedHighlights groups the selected highlights by book title.
+func (m *Model) selectedHighlights() map[string][]models.Highlight {
+	bookHighlights := make(map[string][]models.Highlight)
+
+	for key, selected := range m.selected {
+		if selected {
+			// Parse key format "bookIndex:highlightIndex"
+			var bookIndex, highlightIndex int
+			fmt.Sscanf(key, "%d:%d", &bookIndex, &highlightIndex)
+
+			if bookIndex < len(m.books) && highlightIndex < len(m.books[bookIndex].Highlights) {
+				book := m.books[bookIndex]
+				highlight := book.Highlights[highlightIndex]
+				bookHighlights[book.Title] = append(bookHighlights[book.Title], highlight)
+			}
+		}
+	}
+
+	return bookHighlights
+}
@@ -1,8 +1,6 @@
 package tui
 
 import (
-	"log"
-
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 
@@ -19,6 +17,9 @@ type Model struct {
 	selected map[string]bool // key: "book:highlight" format
 	config   *config.Config
 	exporter *exporter.Service
+	preview  *previewState      // Open dry-run screen, if any
+	report   *ExportCompleteMsg // Failed export being reported, if any
+	height   int
 }
 
 var (
@@ -42,24 +43,32 @@ var (
 			PaddingLeft(2)
 )
 
-func NewModel(clippingsFile string) *Model {
-	cfg := config.Load()
-
-	highlights, err := parser.ParseClippings(clippingsFile)
-	if err != nil {
-		log.Fatalf("Error parsing clippings: %v", err)
-	}
-
-	books := parser.GroupHighlightsByBook(highlights)
+func NewModel(cfg *config.Config, highlights []models.Highlight) *Model {
+	books := visibleBooks(cfg, parser.GroupHighlightsByBook(highlights))
 	items := buildItemList(books)
 
+	// Mirroring compares notes against every highlight, not the selection
+	service := exporter.New(cfg)
+	service.SetSource(highlights)
+
 	return &Model{
 		books:    books,
 		items:    items,
 		selected: make(map[string]bool),
 		config:   cfg,
-		exporter: exporter.New(cfg),
+		exporter: service,
+	}
+}
+
+// visibleBooks drops the books config.toml says to ignore.
+func visibleBooks(cfg *config.Config, books []models.BookGroup) []models.BookGroup {
+	visible := books[:0]
+	for _, book := range books {
+		if !cfg.Book(book.Title, book.ID()).Ignore {
+			visible = append(visible, book)
+		}
 	}
+	return visible
 }
 
 func buildItemList(books []models.BookGroup) []models.ListItem {
@@ -0,0 +1,121 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/matthewrobinsdev/kindle-notes-parser/internal/exporter"
+)
+
+// defaultPreviewHeight is used until the terminal reports its size.
+const defaultPreviewHeight = 20
+
+var (
+	addedStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("#73D216"))
+	removedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#EF2929"))
+	hunkStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("#729FCF"))
+)
+
+type PreviewMsg struct {
+	Preview exporter.Preview
+	Err     error
+}
+
+// previewState is the dry-run screen shown before exporting.
+type previewState struct {
+	preview exporter.Preview
+	err     error
+	lines   []string
+	offset  int
+}
+
+func (m *Model) previewSelected() tea.Cmd {
+	return func() tea.Msg {
+		preview, err := m.exporter.DryRun(m.selectedHighlights())
+		return PreviewMsg{Preview: preview, Err: err}
+	}
+}
+
+func newPreviewState(msg PreviewMsg) *previewState {
+	var lines []string
+	for _, change := range msg.Preview.Changes {
+		lines = append(lines, strings.Split(strings.TrimSuffix(change.Diff(), "\n"), "\n")...)
+		lines = append(lines, "")
+	}
+	if len(lines) == 0 {
+		lines = []string{"Nothing would change."}
+	}
+
+	return &previewState{preview: msg.Preview, err: msg.Err, lines: lines}
+}
+
+func (m *Model) previewHeight() int {
+	if m.height > 8 {
+		return m.height - 6
+	}
+	return defaultPreviewHeight
+}
+
+// updatePreview handles keys while the preview is open: scroll the diff,
+// export with enter or go back with esc.
+func (m *Model) updatePreview(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	maxOffset := max(len(m.preview.lines)-m.previewHeight(), 0)
+
+	switch msg.String() {
+	case "ctrl+c", "q":
+		return m, tea.Quit
+	case "esc", "p":
+		m.preview = nil
+	case "enter":
+		m.preview = nil
+		return m, m.exportSelected()
+	case "up", "k":
+		m.preview.offset = max(m.preview.offset-1, 0)
+	case "down", "j":
+		m.preview.offset = min(m.preview.offset+1, maxOffset)
+	case "pgup", "b":
+		m.preview.offset = max(m.preview.offset-m.previewHeight(), 0)
+	case "pgdown", "f":
+		m.preview.offset = min(m.preview.offset+m.previewHeight(), maxOffset)
+	}
+
+	return m, nil
+}
+
+func (m *Model) previewView() string {
+	created, modified, deleted := m.preview.preview.Counts()
+
+	s := titleStyle.Render("Export Preview") + "\n\n"
+	s += fmt.Sprintf("%d files would be created, %d modified, %d deleted | Scroll: ↑/↓ j/k, PgUp/PgDn | Export: Enter | Back: Esc\n", created, modified, deleted)
+	if removed := m.preview.preview.Removed(); removed > 0 {
+		s += removedStyle.Render(fmt.Sprintf("%d highlights no longer in the source would be removed", removed)) + "\n"
+	}
+	if m.preview.err != nil {
+		s += removedStyle.Render(fmt.Sprintf("Export would fail: %v", m.preview.err)) + "\n"
+	}
+	s += "\n"
+
+	end := min(m.preview.offset+m.previewHeight(), len(m.preview.lines))
+	for _, line := range m.preview.lines[m.preview.offset:end] {
+		s += diffLineStyle(line).Render(line) + "\n"
+	}
+
+	return s
+}
+
+func diffLineStyle(line string) lipgloss.Style {
+	switch {
+	case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		return bookStyle
+	case strings.HasPrefix(line, "@@"):
+		return hunkStyle
+	case strings.HasPrefix(line, "+"):
+		return addedStyle
+	case strings.HasPrefix(line, "-"):
+		return removedStyle
+	}
+	return normalStyle
+}
@@ -9,7 +9,16 @@ import (
 // Update handles messages and updates the model
 func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.height = msg.Height
 	case tea.KeyMsg:
+		if m.report != nil {
+			return m, tea.Quit
+		}
+		if m.preview != nil {
+			return m.updatePreview(msg)
+		}
+
 		switch msg.String() {
 		case "ctrl+c", "q":
 			return m, tea.Quit
@@ -57,9 +66,22 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			// Deselect all highlights globally
 			m.deselectAllHighlights()
 		case "enter":
+			if m.config.Mirror {
+				// Mirroring may remove highlights, so always show what first
+				return m, m.previewSelected()
+			}
 			return m, m.exportSelected()
+		case "p":
+			// Preview the export as a diff before writing
+			return m, m.previewSelected()
 		}
+	case PreviewMsg:
+		m.preview = newPreviewState(msg)
 	case ExportCompleteMsg:
+		if msg.Err != nil {
+			m.report = &msg
+			return m, nil
+		}
 		return m, tea.Quit
 	}
 	return m, nil
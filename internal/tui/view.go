@@ -5,8 +5,15 @@ import (
 )
 
 func (m *Model) View() string {
+	if m.report != nil {
+		return m.exportReportView()
+	}
+	if m.preview != nil {
+		return m.previewView()
+	}
+
 	s := titleStyle.Render("Kindle Highlights Parser") + "\n\n"
-	s += "Navigate: ↑/↓ j/k | Expand/Select: Space | Export: Enter | Quit: q\n"
+	s += "Navigate: ↑/↓ j/k | Expand/Select: Space | Export: Enter | Preview: p | Quit: q\n"
 	s += "Select: a (book) A (all) | Deselect: d (book) D (all)\n\n"
 
 	for i, item := range m.items {
@@ -31,7 +38,7 @@ func (m *Model) View() string {
 				}
 			}
 
-			bookTitle := book.Title
+			bookTitle := m.config.DisplayTitle(book.Title, book.ID())
 			if len(bookTitle) > 45 {
 				bookTitle = bookTitle[:42] + "..."
 			}
@@ -1,5 +1,14 @@
 package models
 
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+)
+
+// idLength is the number of hex characters kept from the SHA-1 digest.
+const idLength = 16
+
 type Highlight struct {
 	Title    string
 	Author   string
@@ -7,13 +16,31 @@ type Highlight struct {
 	Location string
 	Date     string
 	Text     string
+	Note     string
+}
+
+// ID returns a stable identifier derived from the book, location and text,
+// so the same clipping always maps to the same ID across runs.
+func (h Highlight) ID() string {
+	return hashID(h.Title, h.Author, h.Location, h.Text)
 }
 
 type BookGroup struct {
-	Title      string
-	Author     string
-	Highlights []Highlight
-	Expanded   bool
+	Title       string
+	Author      string
+	Highlights  []Highlight
+	Expanded    bool
+	SourceTitle string // Title on the Kindle, when Title is an override
+}
+
+// ID returns a stable identifier derived from the title on the Kindle and
+// the author, so it does not change when the book is shown under another
+// title.
+func (b BookGroup) ID() string {
+	if b.SourceTitle != "" {
+		return hashID(b.SourceTitle, b.Author)
+	}
+	return hashID(b.Title, b.Author)
 }
 
 type ListItem struct {
@@ -24,7 +51,27 @@ type ListItem struct {
 
 type ExportResult struct {
 	BookTitle    string
+	FilePath     string // Note the book was exported to, if it got that far
 	NewCount     int
 	SkippedCount int
+	UpdatedCount int // Managed blocks refreshed from the source
+	RemovedCount int // Managed blocks removed because they left the source
 	TotalCount   int
+	WrittenIDs   []string // Highlights written by this export
+	SkippedIDs   []string // Highlights already in the note
+	RemovedIDs   []string // Highlights removed from the note
+	Conflicts    []Conflict
+	Err          error // Why the book failed to export; nil on success
+}
+
+// Conflict is a managed highlight block that was left alone because it was
+// edited by hand and its source changed too.
+type Conflict struct {
+	HighlightID string
+	Reason      string
+}
+
+func hashID(parts ...string) string {
+	sum := sha1.Sum([]byte(strings.Join(parts, "\x1f")))
+	return hex.EncodeToString(sum[:])[:idLength]
 }
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHighlightID(t *testing.T) {
+	highlight := Highlight{Title: "Sandworm", Author: "Greenberg, Andy", Location: "4933-4934", Text: "Test", Page: "305"}
+
+	assert.Equal(t, "596189418e80089c", highlight.ID(), "ID should be stable across releases")
+	assert.Len(t, highlight.ID(), idLength)
+
+	withDate := highlight
+	withDate.Date = "Monday, 6 May 2024 19:53:44"
+	assert.Equal(t, highlight.ID(), withDate.ID(), "Date should not affect the ID")
+
+	otherText := highlight
+	otherText.Text = "Other"
+	assert.NotEqual(t, highlight.ID(), otherText.ID(), "Different text should give a different ID")
+}
+
+func TestBookGroupID(t *testing.T) {
+	book := BookGroup{Title: "Sandworm", Author: "Greenberg, Andy"}
+
+	assert.Equal(t, "f411d8a50bfeb372", book.ID(), "ID should be stable across releases")
+	assert.NotEqual(t, book.ID(), BookGroup{Title: "Sandworm", Author: "Other"}.ID())
+}